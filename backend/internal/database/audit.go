@@ -0,0 +1,396 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/AlvaroParker/web-console/internal/driver"
+	"github.com/charmbracelet/log"
+)
+
+const (
+	DefaultAuditQueueSize = 4096
+	auditBatchSize        = 256
+	// Key of the advisory lock serializing the writes to the hash chain
+	auditLockKey = 0x61756469
+	// Hash the first entry of the chain links to
+	auditGenesisHash   = "0000000000000000000000000000000000000000000000000000000000000000"
+	auditPurgeInterval = time.Hour
+
+	DefaultAuditLimit = 100
+	MaxAuditLimit     = 1000
+)
+
+// What Record does when the queue of entries waiting to be written is full
+type AuditFullPolicy string
+
+const (
+	// Wait until the entry is queued: the input of the client is stalled meanwhile, nothing is lost
+	AuditBlock AuditFullPolicy = "block"
+	// Refuse the input, the client gets an error and it's never forwarded to the session
+	AuditReject AuditFullPolicy = "reject"
+	// Forward the input without recording it, the number of dropped entries is logged
+	AuditDrop AuditFullPolicy = "drop"
+)
+
+type AuditConfig struct {
+	QueueSize int             // Entries waiting to be written, DefaultAuditQueueSize if 0
+	WhenFull  AuditFullPolicy // AuditBlock if empty
+}
+
+// Entry of the audit log returned by the API
+type AuditRecord struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Email       string    `json:"email"`
+	ContainerID string    `json:"containerid"`
+	SessionID   string    `json:"sessionid"`
+	ClientIP    string    `json:"client_ip"`
+	Input       string    `json:"input"`
+	PrevHash    string    `json:"prev_hash"`
+	Hash        string    `json:"hash"`
+
+	rawInput []byte
+}
+
+// Filters of an audit log query, zero values match everything
+type AuditFilter struct {
+	Email       string
+	ContainerID string
+	SessionID   string
+	From        time.Time
+	To          time.Time
+	Limit       int
+	Offset      int
+}
+
+// Result of the verification of the hash chain
+type AuditVerification struct {
+	Valid        bool   `json:"valid"`
+	Checked      int64  `json:"checked"`
+	FirstID      int64  `json:"first_id"`
+	CheckpointID *int64 `json:"checkpoint_id"` // Last purged entry, the chain starts from its hash
+	InvalidID    *int64 `json:"invalid_id"`    // First entry that doesn't match the chain
+	HeadHash     string `json:"head_hash"`     // Hash of the last entry, store it elsewhere to detect truncation
+}
+
+// Append only writer of the audit log. Entries are written in batches by a single goroutine, each
+// one is chained to the previous one with a SHA-256 hash so any modification is detectable.
+type AuditLog struct {
+	queue    chan driver.AuditEntry
+	whenFull AuditFullPolicy
+	dropped  atomic.Int64
+}
+
+// Start writing audit entries to the database
+func StartAuditLog(config AuditConfig) *AuditLog {
+	if config.QueueSize <= 0 {
+		config.QueueSize = DefaultAuditQueueSize
+	}
+	switch config.WhenFull {
+	case AuditBlock, AuditReject, AuditDrop:
+	default:
+		if config.WhenFull != "" {
+			log.Warn("[database.StartAuditLog] Unknown policy for a full queue, blocking", "policy", config.WhenFull)
+		}
+		config.WhenFull = AuditBlock
+	}
+	auditLog := &AuditLog{queue: make(chan driver.AuditEntry, config.QueueSize), whenFull: config.WhenFull}
+	go auditLog.writeLoop()
+	return auditLog
+}
+
+// Queue an entry to be written. When the queue is full, because the database is slow or down, the
+// `WhenFull` policy applies: by default Record blocks and the session input with it, so the audit
+// trail fails closed.
+func (a *AuditLog) Record(entry driver.AuditEntry) error {
+	entry.Input = append([]byte(nil), entry.Input...)
+	// Postgres stores timestamps with microsecond precision, the hash must match what's stored
+	entry.Time = entry.Time.UTC().Truncate(time.Microsecond)
+	if a.whenFull == AuditBlock {
+		a.queue <- entry
+		return nil
+	}
+	select {
+	case a.queue <- entry:
+		return nil
+	default:
+	}
+	if a.whenFull == AuditReject {
+		return driver.ErrAuditUnavailable
+	}
+	dropped := a.dropped.Add(1)
+	log.Warn("[database.AuditLog] Audit queue full, input not recorded", "session", entry.SessionID, "dropped", dropped)
+	return nil
+}
+
+func (a *AuditLog) writeLoop() {
+	batch := make([]driver.AuditEntry, 0, auditBatchSize)
+	for entry := range a.queue {
+		batch = append(batch[:0], entry)
+	drain:
+		for len(batch) < auditBatchSize {
+			select {
+			case next := <-a.queue:
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		// Retry until the batch is stored, entries are never dropped
+		for attempt := 0; ; attempt++ {
+			errWrite := writeAuditBatch(batch)
+			if errWrite == nil {
+				break
+			}
+			log.Error("[database.AuditLog] Error while writing the audit log", "entries", len(batch), "error", errWrite)
+			time.Sleep(min(time.Duration(attempt+1)*time.Second, 30*time.Second))
+		}
+	}
+}
+
+func writeAuditBatch(batch []driver.AuditEntry) error {
+	tx, errTx := DB.Begin()
+	if errTx != nil {
+		return errTx
+	}
+	defer tx.Rollback()
+
+	if _, errLock := tx.Exec("SELECT pg_advisory_xact_lock($1)", auditLockKey); errLock != nil {
+		return errLock
+	}
+	prevHash := auditGenesisHash
+	errLast := tx.QueryRow("SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1").Scan(&prevHash)
+	if errLast != nil && errLast != sql.ErrNoRows {
+		return errLast
+	}
+
+	for _, entry := range batch {
+		hash := auditHash(prevHash, entry.Time, entry.Email, entry.ContainerID, entry.SessionID, entry.ClientIP, entry.Input)
+		_, errInsert := tx.Exec(`INSERT INTO audit_log(created_at, email, containerid, sessionid, client_ip, input, prev_hash, hash)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			entry.Time, entry.Email, entry.ContainerID, entry.SessionID, entry.ClientIP, entry.Input, prevHash, hash)
+		if errInsert != nil {
+			return errInsert
+		}
+		prevHash = hash
+	}
+	return tx.Commit()
+}
+
+func auditHash(prevHash string, createdAt time.Time, email string, containerID string, sessionID string, clientIP string, input []byte) string {
+	fields := []string{
+		prevHash,
+		createdAt.UTC().Format(time.RFC3339Nano),
+		email,
+		containerID,
+		sessionID,
+		clientIP,
+		hex.EncodeToString(input),
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x1f")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Build the WHERE clause of a filter, returns the clause and its arguments
+func (f AuditFilter) where() (string, []any) {
+	conditions := []string{}
+	args := []any{}
+	add := func(condition string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, strings.Replace(condition, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+	if f.Email != "" {
+		add("email = ?", f.Email)
+	}
+	if f.ContainerID != "" {
+		add("containerid = ?", f.ContainerID)
+	}
+	if f.SessionID != "" {
+		add("sessionid = ?", f.SessionID)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < ?", f.To)
+	}
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+const auditColumns = "id, created_at, email, containerid, sessionid, client_ip, input, prev_hash, hash"
+
+func scanAuditRecord(rows *sql.Rows) (AuditRecord, error) {
+	var record AuditRecord
+	errScan := rows.Scan(&record.ID, &record.CreatedAt, &record.Email, &record.ContainerID, &record.SessionID,
+		&record.ClientIP, &record.rawInput, &record.PrevHash, &record.Hash)
+	if errScan != nil {
+		return record, errScan
+	}
+	record.CreatedAt = record.CreatedAt.UTC()
+	record.Input = strings.ToValidUTF8(string(record.rawInput), string(utf8.RuneError))
+	return record, nil
+}
+
+// Query a page of the audit log, oldest first
+func QueryAudit(filter AuditFilter) ([]AuditRecord, error) {
+	limit := filter.Limit
+	if limit <= 0 || limit > MaxAuditLimit {
+		limit = DefaultAuditLimit
+	}
+	where, args := filter.where()
+	args = append(args, limit, max(filter.Offset, 0))
+	query := "SELECT " + auditColumns + " FROM audit_log" + where +
+		" ORDER BY id LIMIT $" + strconv.Itoa(len(args)-1) + " OFFSET $" + strconv.Itoa(len(args))
+
+	rowsDB, errDB := DB.Query(query, args...)
+	if errDB != nil {
+		return nil, errDB
+	}
+	defer rowsDB.Close()
+
+	records := []AuditRecord{}
+	for rowsDB.Next() {
+		record, errScan := scanAuditRecord(rowsDB)
+		if errScan != nil {
+			return nil, errScan
+		}
+		records = append(records, record)
+	}
+	return records, rowsDB.Err()
+}
+
+// Call fn on every entry matching the filter, oldest first. `Limit` and `Offset` are ignored.
+func ExportAudit(filter AuditFilter, fn func(AuditRecord) error) error {
+	where, args := filter.where()
+	rowsDB, errDB := DB.Query("SELECT "+auditColumns+" FROM audit_log"+where+" ORDER BY id", args...)
+	if errDB != nil {
+		return errDB
+	}
+	defer rowsDB.Close()
+
+	for rowsDB.Next() {
+		record, errScan := scanAuditRecord(rowsDB)
+		if errScan != nil {
+			return errScan
+		}
+		if errFn := fn(record); errFn != nil {
+			return errFn
+		}
+	}
+	return rowsDB.Err()
+}
+
+// Check every entry against its hash and the hash of the previous entry. The chain starts from the
+// genesis hash, or from the hash of the last purged entry stored in the latest checkpoint.
+func VerifyAudit() (*AuditVerification, error) {
+	verification := &AuditVerification{Valid: true, HeadHash: auditGenesisHash}
+	var checkpointID int64
+	errCheckpoint := DB.QueryRow("SELECT last_id, hash FROM audit_checkpoints ORDER BY id DESC LIMIT 1").
+		Scan(&checkpointID, &verification.HeadHash)
+	if errCheckpoint != nil && errCheckpoint != sql.ErrNoRows {
+		return nil, errCheckpoint
+	}
+	if errCheckpoint == nil {
+		verification.CheckpointID = &checkpointID
+	}
+	errExport := ExportAudit(AuditFilter{}, func(record AuditRecord) error {
+		if !verification.check(record) {
+			return errStopVerification
+		}
+		return nil
+	})
+	if errExport != nil && errExport != errStopVerification {
+		return nil, errExport
+	}
+	return verification, nil
+}
+
+var errStopVerification = errors.New("audit chain broken")
+
+// Check that the next entry links to the head of the chain and that its hash matches its content,
+// the head moves to the entry if so. Returns false and sets InvalidID otherwise.
+func (v *AuditVerification) check(record AuditRecord) bool {
+	if v.Checked == 0 {
+		v.FirstID = record.ID
+	}
+	v.Checked++
+	hash := auditHash(record.PrevHash, record.CreatedAt, record.Email, record.ContainerID, record.SessionID, record.ClientIP, record.rawInput)
+	if record.PrevHash != v.HeadHash || record.Hash != hash {
+		id := record.ID
+		v.Valid = false
+		v.InvalidID = &id
+		return false
+	}
+	v.HeadHash = record.Hash
+	return true
+}
+
+// Delete the entries created before the given time. Only a prefix of the chain is deleted and the
+// hash of its last entry is stored as a checkpoint, so the rest can still be verified.
+func PurgeAudit(before time.Time) (int64, error) {
+	tx, errTx := DB.Begin()
+	if errTx != nil {
+		return 0, errTx
+	}
+	defer tx.Rollback()
+
+	if _, errLock := tx.Exec("SELECT pg_advisory_xact_lock($1)", auditLockKey); errLock != nil {
+		return 0, errLock
+	}
+	// The append only trigger lets deletes through only when this is set
+	if _, errSet := tx.Exec("SELECT set_config('webconsole.audit_purge', 'on', true)"); errSet != nil {
+		return 0, errSet
+	}
+	var lastID int64
+	var lastHash string
+	errLast := tx.QueryRow(`SELECT id, hash FROM audit_log WHERE created_at < $1 ORDER BY id DESC LIMIT 1`, before).
+		Scan(&lastID, &lastHash)
+	if errLast == sql.ErrNoRows {
+		return 0, nil
+	}
+	if errLast != nil {
+		return 0, errLast
+	}
+	result, errDelete := tx.Exec("DELETE FROM audit_log WHERE id <= $1", lastID)
+	if errDelete != nil {
+		return 0, errDelete
+	}
+	if _, errCheckpoint := tx.Exec("INSERT INTO audit_checkpoints(last_id, hash) VALUES ($1, $2)", lastID, lastHash); errCheckpoint != nil {
+		return 0, errCheckpoint
+	}
+	if errCommit := tx.Commit(); errCommit != nil {
+		return 0, errCommit
+	}
+	return result.RowsAffected()
+}
+
+// Periodically purge the entries older than the retention, a zero retention keeps them forever
+func StartAuditRetention(retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(auditPurgeInterval)
+		defer ticker.Stop()
+		for {
+			purged, err := PurgeAudit(time.Now().Add(-retention))
+			if err != nil {
+				log.Error("[database.StartAuditRetention] Error while purging the audit log", "error", err)
+			} else if purged > 0 {
+				log.Info("[database.StartAuditRetention] Purged audit log", "entries", purged)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,214 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type auditFields struct {
+	prevHash    string
+	createdAt   time.Time
+	email       string
+	containerID string
+	sessionID   string
+	clientIP    string
+	input       []byte
+}
+
+func (f auditFields) hash() string {
+	return auditHash(f.prevHash, f.createdAt, f.email, f.containerID, f.sessionID, f.clientIP, f.input)
+}
+
+func TestAuditHash(t *testing.T) {
+	// Nanoseconds Postgres can't store, in another zone than the one read back
+	created := time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.FixedZone("UTC-4", -4*60*60))
+	base := auditFields{
+		prevHash:    auditGenesisHash,
+		createdAt:   created.UTC().Truncate(time.Microsecond),
+		email:       "user@test",
+		containerID: "container",
+		sessionID:   "session",
+		clientIP:    "10.0.0.1",
+		input:       []byte("ls\r"),
+	}
+	with := func(change func(f *auditFields)) auditFields {
+		f := base
+		change(&f)
+		return f
+	}
+	// Changing the format breaks the verification of every chain already stored
+	if got, want := base.hash(), "591f939badca27c2edebfdf7e1d5ba152b24285a95fc9b277fa01f7e1a5e8778"; got != want {
+		t.Errorf("auditHash() = %s, want %s", got, want)
+	}
+
+	tests := []struct {
+		name string
+		a, b auditFields
+		same bool
+	}{
+		{"same fields", base, base, true},
+		{
+			"timestamp read back from the database",
+			base,
+			with(func(f *auditFields) { f.createdAt = time.Date(2024, 5, 1, 16, 30, 0, 123456000, time.UTC).Local() }),
+			true,
+		},
+		{
+			"same instant in another zone",
+			base,
+			with(func(f *auditFields) { f.createdAt = f.createdAt.In(created.Location()) }),
+			true,
+		},
+		{"timestamp not truncated", base, with(func(f *auditFields) { f.createdAt = created }), false},
+		{
+			"email and container boundary",
+			with(func(f *auditFields) { f.email, f.containerID = "ab", "c" }),
+			with(func(f *auditFields) { f.email, f.containerID = "a", "bc" }),
+			false,
+		},
+		{
+			"session and client ip boundary",
+			with(func(f *auditFields) { f.sessionID, f.clientIP = "s1", "0.0.0.1" }),
+			with(func(f *auditFields) { f.sessionID, f.clientIP = "s10", ".0.0.1" }),
+			false,
+		},
+		{
+			"client ip and input boundary",
+			with(func(f *auditFields) { f.clientIP, f.input = "10.0.0.1", []byte("a") }),
+			with(func(f *auditFields) { f.clientIP, f.input = "10.0.0.16", []byte{} }),
+			false,
+		},
+		{"previous hash", base, with(func(f *auditFields) { f.prevHash = strings.Repeat("1", 64) }), false},
+		{"input", base, with(func(f *auditFields) { f.input = []byte("ls -a\r") }), false},
+		{"nil and empty input", with(func(f *auditFields) { f.input = nil }), with(func(f *auditFields) { f.input = []byte{} }), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := tt.a.hash(), tt.b.hash()
+			if (a == b) != tt.same {
+				t.Errorf("auditHash() = %s and %s, want same = %v", a, b, tt.same)
+			}
+		})
+	}
+}
+
+// Entries chained from `start`, with ids from 1
+func auditChain(start string, n int) []AuditRecord {
+	records := make([]AuditRecord, n)
+	prevHash := start
+	for i := range records {
+		record := AuditRecord{
+			ID:          int64(i + 1),
+			CreatedAt:   time.Date(2024, 5, 1, 12, 0, i, 0, time.UTC),
+			Email:       "user@test",
+			ContainerID: "container",
+			SessionID:   "session",
+			ClientIP:    "10.0.0.1",
+			PrevHash:    prevHash,
+			rawInput:    []byte{byte('a' + i)},
+		}
+		record.rehash()
+		records[i] = record
+		prevHash = record.Hash
+	}
+	return records
+}
+
+// Recompute the hash after a modification, as someone covering their tracks would
+func (r *AuditRecord) rehash() {
+	r.Hash = auditHash(r.PrevHash, r.CreatedAt, r.Email, r.ContainerID, r.SessionID, r.ClientIP, r.rawInput)
+}
+
+func TestAuditVerificationCheck(t *testing.T) {
+	checkpoint := auditChain(auditGenesisHash, 3)[2].Hash
+	tests := []struct {
+		name        string
+		start       string
+		records     []AuditRecord
+		modify      func(records []AuditRecord)
+		wantInvalid int64 // 0 if the chain is valid
+	}{
+		{"from the genesis", auditGenesisHash, auditChain(auditGenesisHash, 3), nil, 0},
+		{"from a checkpoint", checkpoint, auditChain(checkpoint, 3), nil, 0},
+		{"empty", checkpoint, nil, nil, 0},
+		{
+			"modified prev_hash after a checkpoint",
+			checkpoint,
+			auditChain(checkpoint, 3),
+			func(records []AuditRecord) {
+				records[1].PrevHash = records[0].PrevHash
+				records[1].rehash()
+			},
+			2,
+		},
+		{
+			"first entry relinked to the genesis",
+			checkpoint,
+			auditChain(checkpoint, 3),
+			func(records []AuditRecord) {
+				records[0].PrevHash = auditGenesisHash
+				records[0].rehash()
+			},
+			1,
+		},
+		{
+			"modified hash",
+			checkpoint,
+			auditChain(checkpoint, 3),
+			func(records []AuditRecord) { records[2].Hash = records[1].Hash },
+			3,
+		},
+		{
+			"modified input",
+			checkpoint,
+			auditChain(checkpoint, 3),
+			func(records []AuditRecord) { records[1].rawInput = []byte("rm -rf /\r") },
+			2,
+		},
+		{
+			"deleted entry",
+			checkpoint,
+			auditChain(checkpoint, 3),
+			func(records []AuditRecord) { copy(records[1:], records[2:]) },
+			3,
+		},
+		{"entries deleted without a checkpoint", auditGenesisHash, auditChain(checkpoint, 3), nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.modify != nil {
+				tt.modify(tt.records)
+			}
+			verification := &AuditVerification{Valid: true, HeadHash: tt.start}
+			for _, record := range tt.records {
+				if !verification.check(record) {
+					break
+				}
+			}
+
+			if tt.wantInvalid == 0 {
+				if !verification.Valid || verification.InvalidID != nil {
+					t.Fatalf("check() found entry %d invalid, want a valid chain", *verification.InvalidID)
+				}
+				wantHead := tt.start
+				if len(tt.records) > 0 {
+					wantHead = tt.records[len(tt.records)-1].Hash
+				}
+				if verification.HeadHash != wantHead {
+					t.Errorf("HeadHash = %s, want %s", verification.HeadHash, wantHead)
+				}
+				if verification.Checked != int64(len(tt.records)) {
+					t.Errorf("Checked = %d, want %d", verification.Checked, len(tt.records))
+				}
+				return
+			}
+			if verification.Valid || verification.InvalidID == nil || *verification.InvalidID != tt.wantInvalid {
+				t.Fatalf("check() = valid %v, invalid id %v, want entry %d invalid", verification.Valid, verification.InvalidID, tt.wantInvalid)
+			}
+			if verification.FirstID != 1 {
+				t.Errorf("FirstID = %d, want 1", verification.FirstID)
+			}
+		})
+	}
+}
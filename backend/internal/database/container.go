@@ -37,6 +37,11 @@ type ImagesDB struct {
 	Commands []string `json:"commands"`
 }
 
+// Recording toggle request schema
+type RecordReq struct {
+	Record bool `json:"record"`
+}
+
 // Container configuration and used to store constainer instances on the database
 type Container struct {
 	Image          string  `json:"image"`
@@ -45,6 +50,7 @@ type Container struct {
 	Name           *string `json:"name"`
 	NetworkEnabled bool    `json:"network_enabled"`
 	Command        *string `json:"command"`
+	Record         bool    `json:"record"` // Record every console session as an asciicast file
 }
 
 func (c *Container) GenerateWebContainer(id *string) (*driver.WebContainer, error) {
@@ -61,13 +67,14 @@ func (c *Container) GenerateWebContainer(id *string) (*driver.WebContainer, erro
 		Name:          c.Name,
 		Id:            id,
 		NetworkEnable: c.NetworkEnabled,
+		Record:        c.Record,
 	}, nil
 }
 
 func GetContainer(email string, hash string) (*driver.WebContainer, error) {
 	var container Container
-	query := DB.QueryRow("SELECT image, tag, name, auto_remove, network_enabled, command FROM terminals WHERE email = $1 and containerid = $2", email, hash)
-	queryErr := query.Scan(&container.Image, &container.Tag, &container.Name, &container.AutoRemove, &container.NetworkEnabled, &container.Command)
+	query := DB.QueryRow("SELECT image, tag, name, auto_remove, network_enabled, command, record FROM terminals WHERE email = $1 and containerid = $2", email, hash)
+	queryErr := query.Scan(&container.Image, &container.Tag, &container.Name, &container.AutoRemove, &container.NetworkEnabled, &container.Command, &container.Record)
 	if queryErr != nil {
 		log.Info("[models.ValidateContainer] Error while querying the database: ", queryErr)
 		return nil, queryErr
@@ -81,6 +88,7 @@ func GetContainer(email string, hash string) (*driver.WebContainer, error) {
 		Name:          container.Name,
 		Id:            &hash,
 		NetworkEnable: container.NetworkEnabled,
+		Record:        container.Record,
 	}, nil
 }
 
@@ -106,11 +114,21 @@ func GetContainersMeta(email string) ([]ContainerMeta, error) {
 
 // Add a container ID to the database
 func AddContainerDB(email string, containerID string, container Container) error {
-	_, err := DB.Exec("INSERT INTO terminals (containerid, email, image, tag, name, auto_remove, network_enabled, command) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
-		containerID, email, container.Image, container.Tag, container.Name, container.AutoRemove, container.NetworkEnabled, container.Command)
+	_, err := DB.Exec("INSERT INTO terminals (containerid, email, image, tag, name, auto_remove, network_enabled, command, record) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		containerID, email, container.Image, container.Tag, container.Name, container.AutoRemove, container.NetworkEnabled, container.Command, container.Record)
 	return err
 }
 
+// Enable or disable the recording of the console sessions of a container, applies to new sessions
+func SetRecordContainer(id string, email string, record bool) (bool, error) {
+	sqlRes, errDB := DB.Exec("UPDATE terminals SET record = $1 WHERE containerid = $2 and email = $3", record, id, email)
+	if errDB != nil {
+		return false, errDB
+	}
+	rowsAffected, _ := sqlRes.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
 func DeleteContainerDB(id string, email string) (bool, error) {
 	// Check if the container is running
 	cli, err := client.NewClientWithOpts(client.FromEnv)
@@ -0,0 +1,195 @@
+package database
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlvaroParker/web-console/internal/driver"
+	"github.com/charmbracelet/log"
+)
+
+const (
+	DefaultExecutionLimit   = 50
+	MaxExecutionLimit       = 500
+	executionPurgeInterval  = time.Hour
+	executionSummaryColumns = "id, created_at, email, language, entrypoint, exit_code, wall_time_ms"
+)
+
+// Past execution of a user. The project, stdin, arguments, variables and result are only set when a
+// single execution is requested.
+type Execution struct {
+	ID         int64                   `json:"id"`
+	CreatedAt  time.Time               `json:"created_at"`
+	Email      string                  `json:"email"`
+	Language   string                  `json:"language"`
+	Entrypoint string                  `json:"entrypoint"`
+	ExitCode   int64                   `json:"exit_code"`
+	WallTimeMs int64                   `json:"wall_time_ms"`
+	Files      []driver.CodeFile       `json:"files,omitempty"`
+	Stdin      *string                 `json:"stdin,omitempty"`
+	Args       []string                `json:"args,omitempty"`
+	Env        map[string]string       `json:"env,omitempty"`
+	Result     *driver.ExecutionResult `json:"result,omitempty"`
+}
+
+// Filters of the execution history of a user, zero values match everything
+type ExecutionFilter struct {
+	Email    string
+	Language string
+	From     time.Time
+	To       time.Time
+	Limit    int
+	Offset   int
+}
+
+func (f ExecutionFilter) where() (string, []any) {
+	conditions := []string{}
+	args := []any{}
+	add := func(condition string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, strings.Replace(condition, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+	add("email = ?", f.Email)
+	if f.Language != "" {
+		add("language = ?", f.Language)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < ?", f.To)
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// Store an execution in the history of the user, returns its id
+func AddExecution(email string, language string, project driver.Project, stdin *string, result *driver.ExecutionResult) (int64, error) {
+	files, errFiles := json.Marshal(project.Files)
+	if errFiles != nil {
+		return 0, errFiles
+	}
+	args, errArgs := json.Marshal(project.Args)
+	if errArgs != nil {
+		return 0, errArgs
+	}
+	env, errEnv := json.Marshal(project.Env)
+	if errEnv != nil {
+		return 0, errEnv
+	}
+	jsonResult, errResult := json.Marshal(result)
+	if errResult != nil {
+		return 0, errResult
+	}
+	var id int64
+	errDB := DB.QueryRow(`INSERT INTO executions (email, language, entrypoint, files, stdin, args, env, result, exit_code, wall_time_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+		email, language, project.Entrypoint, files, stdin, args, env, jsonResult, result.ExitCode, result.WallTimeMs).Scan(&id)
+	return id, errDB
+}
+
+// Query a page of the execution history, newest first. Only the summary of each execution is set.
+func QueryExecutions(filter ExecutionFilter) ([]Execution, error) {
+	limit := filter.Limit
+	if limit <= 0 || limit > MaxExecutionLimit {
+		limit = DefaultExecutionLimit
+	}
+	where, args := filter.where()
+	args = append(args, limit, max(filter.Offset, 0))
+	query := "SELECT " + executionSummaryColumns + " FROM executions" + where +
+		" ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args)-1) + " OFFSET $" + strconv.Itoa(len(args))
+
+	rowsDB, errDB := DB.Query(query, args...)
+	if errDB != nil {
+		return nil, errDB
+	}
+	defer rowsDB.Close()
+
+	executions := []Execution{}
+	for rowsDB.Next() {
+		var execution Execution
+		errScan := rowsDB.Scan(&execution.ID, &execution.CreatedAt, &execution.Email, &execution.Language,
+			&execution.Entrypoint, &execution.ExitCode, &execution.WallTimeMs)
+		if errScan != nil {
+			return nil, errScan
+		}
+		executions = append(executions, execution)
+	}
+	return executions, rowsDB.Err()
+}
+
+// Get an execution of the user with its project, stdin, arguments, variables and result. Returns
+// sql.ErrNoRows if it doesn't exist.
+func GetExecution(id int64, email string) (*Execution, error) {
+	var execution Execution
+	var files, args, env, result []byte
+	errDB := DB.QueryRow("SELECT "+executionSummaryColumns+", files, stdin, args, env, result FROM executions WHERE id = $1 AND email = $2", id, email).
+		Scan(&execution.ID, &execution.CreatedAt, &execution.Email, &execution.Language, &execution.Entrypoint,
+			&execution.ExitCode, &execution.WallTimeMs, &files, &execution.Stdin, &args, &env, &result)
+	if errDB != nil {
+		return nil, errDB
+	}
+	if errFiles := json.Unmarshal(files, &execution.Files); errFiles != nil {
+		return nil, errFiles
+	}
+	if errArgs := unmarshalArgs(args, env, &execution.Args, &execution.Env); errArgs != nil {
+		return nil, errArgs
+	}
+	if errResult := json.Unmarshal(result, &execution.Result); errResult != nil {
+		return nil, errResult
+	}
+	return &execution, nil
+}
+
+// Unmarshal the arguments and variables of a program, the columns are NULL for rows stored before
+// programs had them
+func unmarshalArgs(args []byte, env []byte, programArgs *[]string, programEnv *map[string]string) error {
+	if args != nil {
+		if errArgs := json.Unmarshal(args, programArgs); errArgs != nil {
+			return errArgs
+		}
+	}
+	if env != nil {
+		return json.Unmarshal(env, programEnv)
+	}
+	return nil
+}
+
+func DeleteExecution(id int64, email string) (bool, error) {
+	sqlRes, errDB := DB.Exec("DELETE FROM executions WHERE id = $1 AND email = $2", id, email)
+	if errDB != nil {
+		return false, errDB
+	}
+	rowsAffected, _ := sqlRes.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// Delete the executions created before `before`, returns the number of deleted executions
+func PurgeExecutions(before time.Time) (int64, error) {
+	sqlRes, errDB := DB.Exec("DELETE FROM executions WHERE created_at < $1", before)
+	if errDB != nil {
+		return 0, errDB
+	}
+	return sqlRes.RowsAffected()
+}
+
+// Periodically purge the executions older than `retention`, 0 keeps them forever
+func StartExecutionRetention(retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(executionPurgeInterval)
+		defer ticker.Stop()
+		for {
+			purged, err := PurgeExecutions(time.Now().Add(-retention))
+			if err != nil {
+				log.Error("[database.StartExecutionRetention] Error while purging the execution history", "error", err)
+			} else if purged > 0 {
+				log.Info("[database.StartExecutionRetention] Purged execution history", "executions", purged)
+			}
+			<-ticker.C
+		}
+	}()
+}
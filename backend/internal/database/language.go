@@ -0,0 +1,148 @@
+package database
+
+import (
+	"errors"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/AlvaroParker/web-console/internal/driver"
+)
+
+var (
+	ErrInvalidLanguage = errors.New("invalid language")
+
+	languageNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9+#_-]{0,31}$`)
+)
+
+const languageColumns = "name, display_name, version, image, tag, source_path, filename, build_command, run_command, enabled, diagnostics, manifest, install_command, cache_path, build_cache"
+
+// Language as listed for the code editor
+type LanguageInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Version     string `json:"version"`
+	Filename    string `json:"filename"`
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanLanguage(row rowScanner) (*driver.Language, error) {
+	var language driver.Language
+	errScan := row.Scan(&language.Name, &language.DisplayName, &language.Version, &language.Image, &language.Tag,
+		&language.Path, &language.Filename, &language.BuildCommand, &language.RunCommand, &language.Enabled, &language.Diagnostics,
+		&language.Manifest, &language.InstallCommand, &language.CachePath, &language.BuildCache)
+	if errScan != nil {
+		return nil, errScan
+	}
+	return &language, nil
+}
+
+// Get a language by name, disabled languages included. Returns sql.ErrNoRows if it doesn't exist.
+func GetLanguage(name string) (*driver.Language, error) {
+	return scanLanguage(DB.QueryRow("SELECT "+languageColumns+" FROM languages WHERE name = $1", name))
+}
+
+// Get every registered language
+func GetLanguages() ([]driver.Language, error) {
+	rowsDB, errDB := DB.Query("SELECT " + languageColumns + " FROM languages ORDER BY name")
+	if errDB != nil {
+		return nil, errDB
+	}
+	defer rowsDB.Close()
+
+	languages := []driver.Language{}
+	for rowsDB.Next() {
+		language, errScan := scanLanguage(rowsDB)
+		if errScan != nil {
+			return nil, errScan
+		}
+		languages = append(languages, *language)
+	}
+	return languages, rowsDB.Err()
+}
+
+// Get the languages available in the code editor
+func GetEnabledLanguages() ([]LanguageInfo, error) {
+	rowsDB, errDB := DB.Query("SELECT name, display_name, version, filename FROM languages WHERE enabled ORDER BY display_name")
+	if errDB != nil {
+		return nil, errDB
+	}
+	defer rowsDB.Close()
+
+	languages := []LanguageInfo{}
+	for rowsDB.Next() {
+		var language LanguageInfo
+		if errScan := rowsDB.Scan(&language.Name, &language.DisplayName, &language.Version, &language.Filename); errScan != nil {
+			return nil, errScan
+		}
+		languages = append(languages, language)
+	}
+	return languages, rowsDB.Err()
+}
+
+// Check the fields of a language before storing it
+func ValidateLanguage(language *driver.Language) error {
+	if !languageNameRegex.MatchString(language.Name) {
+		return ErrInvalidLanguage
+	}
+	if language.DisplayName == "" {
+		language.DisplayName = language.Name
+	}
+	if language.Tag == "" {
+		language.Tag = "latest"
+	}
+	if language.Image == "" || strings.ContainsAny(language.Image+language.Tag, " :@") ||
+		!path.IsAbs(language.Path) ||
+		// The default entrypoint can be in a subdirectory of the source directory
+		!isRelativePath(language.Filename) ||
+		strings.TrimSpace(language.RunCommand) == "" || !driver.IsDiagnosticParser(language.Diagnostics) {
+		return ErrInvalidLanguage
+	}
+	// The install needs a manifest to detect projects with dependencies
+	if language.InstallCommand != "" && !isRelativePath(language.Manifest) ||
+		language.CachePath != "" && !path.IsAbs(language.CachePath) ||
+		language.BuildCache != "" && !path.IsAbs(language.BuildCache) {
+		return ErrInvalidLanguage
+	}
+	return nil
+}
+
+// Clean path in the source directory of the language
+func isRelativePath(name string) bool {
+	return name != "" && !path.IsAbs(name) && path.Clean(name) == name && !strings.HasPrefix(name, "..")
+}
+
+func AddLanguage(language *driver.Language) error {
+	_, errDB := DB.Exec("INSERT INTO languages("+languageColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)",
+		language.Name, language.DisplayName, language.Version, language.Image, language.Tag,
+		language.Path, language.Filename, language.BuildCommand, language.RunCommand, language.Enabled, language.Diagnostics,
+		language.Manifest, language.InstallCommand, language.CachePath, language.BuildCache)
+	return errDB
+}
+
+// Update every field of the language but its name, returns false if it doesn't exist
+func UpdateLanguage(language *driver.Language) (bool, error) {
+	sqlRes, errDB := DB.Exec(`UPDATE languages SET display_name = $2, version = $3, image = $4, tag = $5, source_path = $6,
+		filename = $7, build_command = $8, run_command = $9, enabled = $10, diagnostics = $11,
+		manifest = $12, install_command = $13, cache_path = $14, build_cache = $15 WHERE name = $1`,
+		language.Name, language.DisplayName, language.Version, language.Image, language.Tag,
+		language.Path, language.Filename, language.BuildCommand, language.RunCommand, language.Enabled, language.Diagnostics,
+		language.Manifest, language.InstallCommand, language.CachePath, language.BuildCache)
+	if errDB != nil {
+		return false, errDB
+	}
+	rowsAffected, _ := sqlRes.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+func DeleteLanguage(name string) (bool, error) {
+	sqlRes, errDB := DB.Exec("DELETE FROM languages WHERE name = $1", name)
+	if errDB != nil {
+		return false, errDB
+	}
+	rowsAffected, _ := sqlRes.RowsAffected()
+	return rowsAffected > 0, nil
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/AlvaroParker/web-console/internal/driver"
+)
+
+func TestValidateLanguage(t *testing.T) {
+	valid := driver.Language{
+		Name:       "python",
+		Image:      "python",
+		Tag:        "3.12",
+		Path:       "/app",
+		Filename:   "main.py",
+		RunCommand: `python3 "$ENTRYPOINT"`,
+	}
+	tests := []struct {
+		name   string
+		modify func(language *driver.Language)
+		valid  bool
+	}{
+		{"valid", func(language *driver.Language) {}, true},
+		{"symbols in name", func(language *driver.Language) { language.Name = "c++" }, true},
+		{"uppercase name", func(language *driver.Language) { language.Name = "Python" }, false},
+		{"empty name", func(language *driver.Language) { language.Name = "" }, false},
+		{"name too long", func(language *driver.Language) { language.Name = "a23456789012345678901234567890123" }, false},
+		{"empty image", func(language *driver.Language) { language.Image = "" }, false},
+		{"tag in image", func(language *driver.Language) { language.Image = "python:3.12" }, false},
+		{"digest in tag", func(language *driver.Language) { language.Tag = "3.12@sha256" }, false},
+		{"empty tag", func(language *driver.Language) { language.Tag = "" }, true},
+		{"relative path", func(language *driver.Language) { language.Path = "app" }, false},
+		{"filename in subdirectory", func(language *driver.Language) { language.Filename = "src/main.py" }, true},
+		{"absolute filename", func(language *driver.Language) { language.Filename = "/app/main.py" }, false},
+		{"filename outside", func(language *driver.Language) { language.Filename = "../main.py" }, false},
+		{"unclean filename", func(language *driver.Language) { language.Filename = "src/../main.py" }, false},
+		{"blank run command", func(language *driver.Language) { language.RunCommand = "  " }, false},
+		{"known diagnostics", func(language *driver.Language) { language.Diagnostics = "gcc" }, true},
+		{"unknown diagnostics", func(language *driver.Language) { language.Diagnostics = "javac" }, false},
+		{"install without manifest", func(language *driver.Language) { language.InstallCommand = "pip install" }, false},
+		{"install with manifest", func(language *driver.Language) {
+			language.InstallCommand = "pip install"
+			language.Manifest = "requirements.txt"
+		}, true},
+		{"relative cache path", func(language *driver.Language) { language.CachePath = "cache" }, false},
+		{"relative build cache", func(language *driver.Language) { language.BuildCache = "target" }, false},
+		{"absolute build cache", func(language *driver.Language) { language.BuildCache = "/root/.cache" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			language := valid
+			tt.modify(&language)
+			err := ValidateLanguage(&language)
+			if (err == nil) != tt.valid {
+				t.Fatalf("ValidateLanguage() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestValidateLanguageDefaults(t *testing.T) {
+	language := driver.Language{Name: "bash", Image: "bash", Path: "/app", Filename: "main.sh", RunCommand: "bash main.sh"}
+	if err := ValidateLanguage(&language); err != nil {
+		t.Fatalf("ValidateLanguage() = %v", err)
+	}
+	if language.DisplayName != "bash" || language.Tag != "latest" {
+		t.Errorf("defaults = %q, %q, want %q, %q", language.DisplayName, language.Tag, "bash", "latest")
+	}
+}
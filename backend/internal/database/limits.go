@@ -0,0 +1,90 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/AlvaroParker/web-console/internal/driver"
+)
+
+// Get the execution limits for a user on a language. Each limit is taken from the most specific row
+// that sets it: user and language, user, language, and finally `defaults`.
+func GetExecutionLimits(email string, language string, defaults driver.ExecutionLimits) (driver.ExecutionLimits, error) {
+	rowsDB, errorDB := DB.Query(`SELECT memory_mb, cpus, pids_limit, output_bytes, timeout FROM execution_limits
+		WHERE (email = $1 OR email IS NULL) AND (language = $2 OR language IS NULL)
+		ORDER BY (email IS NULL), (language IS NULL)`, email, language)
+	if errorDB != nil {
+		return defaults, errorDB
+	}
+	defer rowsDB.Close()
+
+	var memory, pids, output, timeout sql.Null[int64]
+	var cpus sql.Null[float64]
+	errMerge := mergeSpecific(rowsDB, column(&memory), column(&cpus), column(&pids), column(&output), column(&timeout))
+	if errMerge != nil {
+		return defaults, errMerge
+	}
+
+	limits := defaults
+	if memory.Valid {
+		limits.MemoryMB = memory.V
+	}
+	if cpus.Valid {
+		limits.CPUs = cpus.V
+	}
+	if pids.Valid {
+		limits.PidsLimit = pids.V
+	}
+	if output.Valid {
+		limits.MaxOutputBytes = int(output.V)
+	}
+	if timeout.Valid {
+		limits.Timeout = time.Duration(timeout.V) * time.Second
+	}
+	return limits, nil
+}
+
+// Column of mergeSpecific: each row is scanned into `dest`, then `merge` keeps its value if none
+// was kept yet
+type mergedColumn interface {
+	dest() any
+	merge()
+}
+
+type specificColumn[T any] struct {
+	merged *sql.Null[T]
+	row    sql.Null[T]
+}
+
+// Column merged into `merged`, which is left invalid if no row sets it
+func column[T any](merged *sql.Null[T]) mergedColumn {
+	return &specificColumn[T]{merged: merged}
+}
+
+func (c *specificColumn[T]) dest() any {
+	return &c.row
+}
+
+func (c *specificColumn[T]) merge() {
+	if !c.merged.Valid {
+		*c.merged = c.row
+	}
+}
+
+// Scan rows ordered from the most to the least specific, each column gets the value of the first row
+// where it isn't NULL. The columns are given in the order of the query.
+func mergeSpecific(rows *sql.Rows, columns ...mergedColumn) error {
+	dest := make([]any, len(columns))
+	for i, column := range columns {
+		dest[i] = column.dest()
+	}
+	for rows.Next() {
+		if errScan := rows.Scan(dest...); errScan != nil {
+			return errScan
+		}
+		for _, column := range columns {
+			column.merge()
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,34 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/AlvaroParker/web-console/internal/driver"
+)
+
+// Get the console session policy for a user on an image. Each limit is taken from the most specific
+// policy that sets it: user and image, user, image, and finally `defaults`.
+func GetConsolePolicy(email string, imageTag string, defaults driver.SessionPolicy) (driver.SessionPolicy, error) {
+	rowsDB, errorDB := DB.Query(`SELECT idle_timeout, max_duration FROM console_policies
+		WHERE (email = $1 OR email IS NULL) AND (image_tag = $2 OR image_tag IS NULL)
+		ORDER BY (email IS NULL), (image_tag IS NULL)`, email, imageTag)
+	if errorDB != nil {
+		return defaults, errorDB
+	}
+	defer rowsDB.Close()
+
+	var idleTimeout, maxDuration sql.Null[int64]
+	if errMerge := mergeSpecific(rowsDB, column(&idleTimeout), column(&maxDuration)); errMerge != nil {
+		return defaults, errMerge
+	}
+
+	policy := defaults
+	if idleTimeout.Valid {
+		policy.IdleTimeout = time.Duration(idleTimeout.V) * time.Second
+	}
+	if maxDuration.Valid {
+		policy.MaxDuration = time.Duration(maxDuration.V) * time.Second
+	}
+	return policy, nil
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"github.com/AlvaroParker/web-console/internal/driver"
+)
+
+// A user the console of a container is shared with
+type Share struct {
+	Email string      `json:"email"`
+	Role  driver.Role `json:"role"`
+}
+
+// Get a container that is either owned by or shared with the given email. Returns the owner of the
+// container and the role of the user on it.
+func GetContainerAccess(email string, hash string) (*driver.WebContainer, string, driver.Role, error) {
+	var container Container
+	var owner string
+	var role driver.Role
+	query := DB.QueryRow(`SELECT t.image, t.tag, t.name, t.auto_remove, t.network_enabled, t.command, t.record, t.email, COALESCE(s.role, $3)
+		FROM terminals t LEFT JOIN shares s ON s.containerid = t.containerid AND s.email = $1
+		WHERE t.containerid = $2 AND (t.email = $1 OR s.email IS NOT NULL)`, email, hash, driver.RoleOwner)
+	queryErr := query.Scan(&container.Image, &container.Tag, &container.Name, &container.AutoRemove, &container.NetworkEnabled, &container.Command, &container.Record, &owner, &role)
+	if queryErr != nil {
+		return nil, "", "", queryErr
+	}
+
+	wc, errWc := container.GenerateWebContainer(&hash)
+	if errWc != nil {
+		return nil, "", "", errWc
+	}
+	return wc, owner, role, nil
+}
+
+func GetShares(containerID string) ([]Share, error) {
+	rowsDB, errorDB := DB.Query("SELECT email, role FROM shares WHERE containerid = $1 ORDER BY id", containerID)
+	if errorDB != nil {
+		return nil, errorDB
+	}
+	defer rowsDB.Close()
+	shares := []Share{}
+	for rowsDB.Next() {
+		var share Share
+		if errScan := rowsDB.Scan(&share.Email, &share.Role); errScan != nil {
+			return nil, errScan
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
+
+// Share the container with a user, updating the role if it was already shared
+func AddShare(containerID string, share Share) error {
+	_, err := DB.Exec(`INSERT INTO shares (containerid, email, role) VALUES ($1, $2, $3)
+		ON CONFLICT (containerid, email) DO UPDATE SET role = EXCLUDED.role`, containerID, share.Email, share.Role)
+	return err
+}
+
+func DeleteShare(containerID string, email string) (bool, error) {
+	sqlRes, errDB := DB.Exec("DELETE FROM shares WHERE containerid = $1 AND email = $2", containerID, email)
+	if errDB != nil {
+		return false, errDB
+	}
+	rowsAffected, _ := sqlRes.RowsAffected()
+	return rowsAffected > 0, nil
+}
@@ -0,0 +1,252 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlvaroParker/web-console/internal/driver"
+	"github.com/lib/pq"
+)
+
+const (
+	DefaultSnippetLimit   = 50
+	MaxSnippetLimit       = 500
+	MaxSnippetNameLength  = 128
+	snippetIDLength       = 8
+	snippetIDAlphabet     = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	snippetIDAttempts     = 5
+	snippetSummaryColumns = "id, email, name, language, entrypoint, visibility, forked_from, created_at, updated_at"
+)
+
+// Who can open a snippet besides its owner
+type Visibility string
+
+const (
+	VisibilityPrivate Visibility = "private" // Only the owner
+	VisibilityLink    Visibility = "link"    // Anyone with the id of the snippet
+	VisibilityPublic  Visibility = "public"  // Anyone, and listed in the public snippets
+)
+
+var ErrInvalidSnippet = errors.New("invalid snippet")
+
+// Saved code of a user, the files, stdin, arguments and variables are only set when a single snippet
+// is requested
+type Snippet struct {
+	ID         string            `json:"id"`
+	Email      string            `json:"email,omitempty"` // Owner, see HideOwner
+	Name       string            `json:"name"`
+	Language   string            `json:"language"`
+	Entrypoint string            `json:"entrypoint"`
+	Visibility Visibility        `json:"visibility"`
+	ForkedFrom *string           `json:"forked_from,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+	Files      []driver.CodeFile `json:"files,omitempty"`
+	Stdin      *string           `json:"stdin,omitempty"`
+	Args       []string          `json:"args,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+}
+
+// Filters of the snippets, zero values match everything
+type SnippetFilter struct {
+	Email      string
+	Language   string
+	Visibility Visibility
+	Limit      int
+	Offset     int
+}
+
+func (f SnippetFilter) where() (string, []any) {
+	conditions := []string{}
+	args := []any{}
+	add := func(condition string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, strings.Replace(condition, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+	if f.Email != "" {
+		add("email = ?", f.Email)
+	}
+	if f.Language != "" {
+		add("language = ?", f.Language)
+	}
+	if f.Visibility != "" {
+		add("visibility = ?", f.Visibility)
+	}
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// The owner can always view the snippet, other users only if it isn't private
+func (s *Snippet) CanView(email string) bool {
+	return s.Email == email || s.Visibility == VisibilityLink || s.Visibility == VisibilityPublic
+}
+
+// Clear the email of the owner unless the user is the owner, it's their login and other users
+// viewing a shared or public snippet must not learn it
+func (s *Snippet) HideOwner(email string) {
+	if s.Email != email {
+		s.Email = ""
+	}
+}
+
+// Check the name and visibility of the snippet, the visibility defaults to private
+func ValidateSnippet(snippet *Snippet) error {
+	snippet.Name = strings.TrimSpace(snippet.Name)
+	if snippet.Name == "" || len(snippet.Name) > MaxSnippetNameLength {
+		return ErrInvalidSnippet
+	}
+	switch snippet.Visibility {
+	case "":
+		snippet.Visibility = VisibilityPrivate
+	case VisibilityPrivate, VisibilityLink, VisibilityPublic:
+	default:
+		return ErrInvalidSnippet
+	}
+	return nil
+}
+
+// Store a new snippet, its id and timestamps are set
+func AddSnippet(snippet *Snippet) error {
+	files, args, env, errJSON := snippet.marshalProject()
+	if errJSON != nil {
+		return errJSON
+	}
+	// Short ids can collide, a new one is drawn until the insert succeeds
+	for attempt := 1; ; attempt++ {
+		id, errID := newSnippetID()
+		if errID != nil {
+			return errID
+		}
+		errDB := DB.QueryRow(`INSERT INTO snippets (id, email, name, language, entrypoint, files, stdin, args, env, visibility, forked_from)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING created_at, updated_at`,
+			id, snippet.Email, snippet.Name, snippet.Language, snippet.Entrypoint, files, snippet.Stdin, args, env, snippet.Visibility, snippet.ForkedFrom).
+			Scan(&snippet.CreatedAt, &snippet.UpdatedAt)
+		var errPQ *pq.Error
+		if errors.As(errDB, &errPQ) && errPQ.Code == "23505" && errPQ.Constraint == "snippets_pkey" && attempt < snippetIDAttempts {
+			continue
+		}
+		if errDB != nil {
+			return errDB
+		}
+		snippet.ID = id
+		return nil
+	}
+}
+
+// Query a page of snippets, most recently updated first. Only the summary of each snippet is set.
+func QuerySnippets(filter SnippetFilter) ([]Snippet, error) {
+	limit := filter.Limit
+	if limit <= 0 || limit > MaxSnippetLimit {
+		limit = DefaultSnippetLimit
+	}
+	where, args := filter.where()
+	args = append(args, limit, max(filter.Offset, 0))
+	query := "SELECT " + snippetSummaryColumns + " FROM snippets" + where +
+		" ORDER BY updated_at DESC, id LIMIT $" + strconv.Itoa(len(args)-1) + " OFFSET $" + strconv.Itoa(len(args))
+
+	rowsDB, errDB := DB.Query(query, args...)
+	if errDB != nil {
+		return nil, errDB
+	}
+	defer rowsDB.Close()
+
+	snippets := []Snippet{}
+	for rowsDB.Next() {
+		var snippet Snippet
+		errScan := rowsDB.Scan(&snippet.ID, &snippet.Email, &snippet.Name, &snippet.Language, &snippet.Entrypoint,
+			&snippet.Visibility, &snippet.ForkedFrom, &snippet.CreatedAt, &snippet.UpdatedAt)
+		if errScan != nil {
+			return nil, errScan
+		}
+		snippets = append(snippets, snippet)
+	}
+	return snippets, rowsDB.Err()
+}
+
+// Get a snippet with its files, stdin, arguments and variables, whoever owns it. Returns
+// sql.ErrNoRows if it doesn't exist.
+func GetSnippet(id string) (*Snippet, error) {
+	var snippet Snippet
+	var files, args, env []byte
+	errDB := DB.QueryRow("SELECT "+snippetSummaryColumns+", files, stdin, args, env FROM snippets WHERE id = $1", id).
+		Scan(&snippet.ID, &snippet.Email, &snippet.Name, &snippet.Language, &snippet.Entrypoint, &snippet.Visibility,
+			&snippet.ForkedFrom, &snippet.CreatedAt, &snippet.UpdatedAt, &files, &snippet.Stdin, &args, &env)
+	if errDB != nil {
+		return nil, errDB
+	}
+	if errFiles := json.Unmarshal(files, &snippet.Files); errFiles != nil {
+		return nil, errFiles
+	}
+	if errArgs := unmarshalArgs(args, env, &snippet.Args, &snippet.Env); errArgs != nil {
+		return nil, errArgs
+	}
+	return &snippet, nil
+}
+
+// Replace the name, code and visibility of a snippet of the user, its timestamps are set
+func UpdateSnippet(snippet *Snippet) (bool, error) {
+	files, args, env, errJSON := snippet.marshalProject()
+	if errJSON != nil {
+		return false, errJSON
+	}
+	rowsDB, errDB := DB.Query(`UPDATE snippets SET name = $3, language = $4, entrypoint = $5, files = $6, stdin = $7,
+		args = $8, env = $9, visibility = $10, updated_at = now() WHERE id = $1 AND email = $2
+		RETURNING forked_from, created_at, updated_at`,
+		snippet.ID, snippet.Email, snippet.Name, snippet.Language, snippet.Entrypoint, files, snippet.Stdin, args, env, snippet.Visibility)
+	if errDB != nil {
+		return false, errDB
+	}
+	defer rowsDB.Close()
+	if !rowsDB.Next() {
+		return false, rowsDB.Err()
+	}
+	return true, rowsDB.Scan(&snippet.ForkedFrom, &snippet.CreatedAt, &snippet.UpdatedAt)
+}
+
+func DeleteSnippet(id string, email string) (bool, error) {
+	sqlRes, errDB := DB.Exec("DELETE FROM snippets WHERE id = $1 AND email = $2", id, email)
+	if errDB != nil {
+		return false, errDB
+	}
+	rowsAffected, _ := sqlRes.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// JSON columns of the files, arguments and variables
+func (s *Snippet) marshalProject() ([]byte, []byte, []byte, error) {
+	files, errFiles := json.Marshal(s.Files)
+	if errFiles != nil {
+		return nil, nil, nil, errFiles
+	}
+	args, errArgs := json.Marshal(s.Args)
+	if errArgs != nil {
+		return nil, nil, nil, errArgs
+	}
+	env, errEnv := json.Marshal(s.Env)
+	return files, args, env, errEnv
+}
+
+// Random id of `snippetIDLength` alphanumeric characters
+func newSnippetID() (string, error) {
+	id := make([]byte, 0, snippetIDLength)
+	buf := make([]byte, snippetIDLength*2)
+	for len(id) < snippetIDLength {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			// Bytes past the last multiple of the alphabet size are skipped, so every character is
+			// equally likely
+			if int(b) < 256-256%len(snippetIDAlphabet) && len(id) < snippetIDLength {
+				id = append(id, snippetIDAlphabet[int(b)%len(snippetIDAlphabet)])
+			}
+		}
+	}
+	return string(id), nil
+}
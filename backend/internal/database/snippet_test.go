@@ -0,0 +1,24 @@
+package database
+
+import "testing"
+
+func TestSnippetHideOwner(t *testing.T) {
+	tests := []struct {
+		name   string
+		viewer string
+		want   string
+	}{
+		{"owner", "owner@test", "owner@test"},
+		{"other user", "other@test", ""},
+		{"no user", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snippet := Snippet{Email: "owner@test", Visibility: VisibilityPublic}
+			snippet.HideOwner(tt.viewer)
+			if snippet.Email != tt.want {
+				t.Errorf("Email = %q, want %q", snippet.Email, tt.want)
+			}
+		})
+	}
+}
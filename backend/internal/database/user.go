@@ -177,3 +177,10 @@ func ExpireCookie() time.Time {
 	// Send beggining of times
 	return time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
 }
+
+// Check if the user is an administrator of the deployment
+func IsAdmin(email string) (bool, error) {
+	var exists bool
+	errDB := DB.QueryRow("SELECT EXISTS(SELECT 1 FROM admins WHERE email = $1)", email).Scan(&exists)
+	return exists, errDB
+}
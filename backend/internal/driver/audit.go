@@ -0,0 +1,40 @@
+package driver
+
+import (
+	"errors"
+	"time"
+)
+
+// The input couldn't be added to the audit trail and wasn't forwarded
+var ErrAuditUnavailable = errors.New("input refused, the audit trail is unavailable")
+
+// Input sent by a client to a session
+type AuditEntry struct {
+	Time        time.Time
+	Email       string
+	ContainerID string
+	SessionID   string
+	ClientIP    string
+	Input       []byte
+}
+
+// Receives every input forwarded to a session when the audit mode is enabled. It's called from the
+// input loop of the client before the input is forwarded: the input is refused if it returns an
+// error.
+type AuditSink func(entry AuditEntry) error
+
+// Send input of a client to the audit sink, if any
+func (s *Session) audit(client *Client, input []byte) error {
+	sink := s.manager.config.Audit
+	if sink == nil {
+		return nil
+	}
+	return sink(AuditEntry{
+		Time:        time.Now(),
+		Email:       client.Email,
+		ContainerID: s.ContainerID,
+		SessionID:   s.ID,
+		ClientIP:    client.IP,
+		Input:       input,
+	})
+}
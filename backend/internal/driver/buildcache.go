@@ -0,0 +1,160 @@
+package driver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+)
+
+const (
+	DefaultBuildCacheMaxBytes      = 1024 * 1024 * 1024
+	DefaultBuildCacheMaxTotalBytes = 10 * 1024 * 1024 * 1024
+	// Label of the build cache volumes, its value is the name of the language
+	buildCacheLabel = "webconsole.build-cache"
+	// Prefix of the build cache volumes, followed by the name of the language and the hash of the user
+	buildCacheVolumePrefix = "webconsole-build-"
+	buildCacheTimeout      = time.Minute
+)
+
+// Configuration of the build caches of the compiled languages. The builds write to the caches, so
+// each user has their own cache of each language.
+type BuildCacheConfig struct {
+	Enabled       bool
+	MaxBytes      int64 // Caches bigger than this are removed before being mounted, 0 for no limit
+	MaxTotalBytes int64 // The least recently used caches are removed above it, 0 for no limit
+}
+
+// Volumes keeping the build outputs and compiler caches between runs, so builds are incremental
+type buildCaches struct {
+	config   BuildCacheConfig
+	evictMu  sync.Mutex // Sizing the caches walks every volume, a single eviction runs at a time
+	mu       sync.Mutex
+	lastUsed map[string]time.Time // By volume name, since the server started
+}
+
+// nil when the build caches are disabled
+var buildCache *buildCaches
+
+// Init the build caches, must be call on server initialization
+func InitBuildCaches(config BuildCacheConfig) {
+	if !config.Enabled {
+		return
+	}
+	buildCache = &buildCaches{config: config, lastUsed: map[string]time.Time{}}
+}
+
+// Whether executions of the language mount a build cache
+func (c *buildCaches) enabled(language Language) bool {
+	return c != nil && language.BuildCache != "" && language.BuildCommand != ""
+}
+
+// Mount of the build cache of the language for the user, nil if the language has none, doesn't build
+// or there's no user. The caches over the limits are evicted first: if the cache of the user is too
+// big but can't be removed, because another execution uses it, nil is returned and the build runs
+// without a cache. The volume is created with the label of the build caches if it doesn't exist.
+func (c *buildCaches) mount(ctx context.Context, language Language, user string) (*mount.Mount, error) {
+	if !c.enabled(language) || user == "" {
+		return nil, nil
+	}
+	hash := sha256.Sum256([]byte(user))
+	name := buildCacheVolumePrefix + language.Name + "-" + hex.EncodeToString(hash[:8])
+	// Used now so it's the last cache evicted to fit in the total limit
+	c.mu.Lock()
+	c.lastUsed[name] = time.Now()
+	c.mu.Unlock()
+	if !c.evict(ctx, name) {
+		log.Warn("[driver.buildCaches.mount] Build cache over the size limit, building without it", "volume", name)
+		return nil, nil
+	}
+	_, errCreate := dockerClient.VolumeCreate(ctx, volume.CreateOptions{
+		Name:   name,
+		Labels: map[string]string{buildCacheLabel: language.Name},
+	})
+	if errCreate != nil {
+		return nil, errCreate
+	}
+	return &mount.Mount{Type: mount.TypeVolume, Source: name, Target: language.BuildCache}, nil
+}
+
+type cacheVolume struct {
+	name     string
+	size     int64
+	lastUsed time.Time
+}
+
+// Evict the caches over the limits before mounting the cache `name`. Returns false if the cache is
+// still over the size limit, or its size is unknown.
+func (c *buildCaches) evict(ctx context.Context, name string) bool {
+	c.evictMu.Lock()
+	defer c.evictMu.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, buildCacheTimeout)
+	defer cancel()
+	usage, errUsage := dockerClient.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.VolumeObject}})
+	if errUsage != nil {
+		log.Error("[driver.buildCaches.evict] Error while getting the size of the build caches", "error", errUsage)
+		return false
+	}
+
+	c.mu.Lock()
+	volumes := []cacheVolume{}
+	for _, vol := range usage.Volumes {
+		if _, ok := vol.Labels[buildCacheLabel]; !ok {
+			continue
+		}
+		cache := cacheVolume{name: vol.Name, lastUsed: c.lastUsed[vol.Name]}
+		// The size is -1 when docker couldn't compute it
+		if vol.UsageData != nil && vol.UsageData.Size > 0 {
+			cache.size = vol.UsageData.Size
+		}
+		// Caches unused since the server started are the least recently used, oldest first
+		if cache.lastUsed.IsZero() {
+			cache.lastUsed, _ = time.Parse(time.RFC3339, vol.CreatedAt)
+		}
+		volumes = append(volumes, cache)
+	}
+	c.mu.Unlock()
+
+	return c.evictVolumes(volumes, name, func(volume string) error {
+		return dockerClient.VolumeRemove(ctx, volume, false)
+	})
+}
+
+// Remove the caches over the size limit, then the least recently used ones until all the caches fit
+// in the total limit. Caches mounted by a running execution can't be removed and are skipped.
+// Returns false if the cache `name` is still over the size limit.
+func (c *buildCaches) evictVolumes(volumes []cacheVolume, name string, remove func(volume string) error) bool {
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].lastUsed.Before(volumes[j].lastUsed) })
+	var total int64
+	for _, cache := range volumes {
+		total += cache.size
+	}
+	fits := true
+	for _, cache := range volumes {
+		oversized := c.config.MaxBytes > 0 && cache.size > c.config.MaxBytes
+		overTotal := c.config.MaxTotalBytes > 0 && total > c.config.MaxTotalBytes
+		if !oversized && !overTotal {
+			continue
+		}
+		if errRemove := remove(cache.name); errRemove != nil {
+			log.Debug("[driver.buildCaches.evictVolumes] Build cache not removed", "volume", cache.name, "error", errRemove)
+			if cache.name == name && oversized {
+				fits = false
+			}
+			continue
+		}
+		log.Info("[driver.buildCaches.evictVolumes] Removed build cache", "volume", cache.name, "bytes", cache.size)
+		total -= cache.size
+		c.mu.Lock()
+		delete(c.lastUsed, cache.name)
+		c.mu.Unlock()
+	}
+	return fits
+}
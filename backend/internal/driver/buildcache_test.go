@@ -0,0 +1,110 @@
+package driver
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEvictVolumes(t *testing.T) {
+	now := time.Now()
+	volume := func(name string, size int64, age time.Duration) cacheVolume {
+		return cacheVolume{name: name, size: size, lastUsed: now.Add(-age)}
+	}
+	errInUse := errors.New("volume in use")
+	tests := []struct {
+		name        string
+		config      BuildCacheConfig
+		volumes     []cacheVolume
+		inUse       []string
+		wantRemoved []string
+		wantFits    bool
+	}{
+		{
+			name:     "under the limits",
+			config:   BuildCacheConfig{MaxBytes: 100, MaxTotalBytes: 300},
+			volumes:  []cacheVolume{volume("a", 100, time.Hour), volume("mounted", 100, 0)},
+			wantFits: true,
+		},
+		{
+			name:        "oversized",
+			config:      BuildCacheConfig{MaxBytes: 100},
+			volumes:     []cacheVolume{volume("a", 101, time.Hour), volume("mounted", 150, 0)},
+			wantRemoved: []string{"a", "mounted"},
+			wantFits:    true,
+		},
+		{
+			name:        "oversized and in use",
+			config:      BuildCacheConfig{MaxBytes: 100},
+			volumes:     []cacheVolume{volume("mounted", 150, 0)},
+			inUse:       []string{"mounted"},
+			wantRemoved: []string{},
+			wantFits:    false,
+		},
+		{
+			name:        "least recently used first",
+			config:      BuildCacheConfig{MaxTotalBytes: 200},
+			volumes:     []cacheVolume{volume("mounted", 100, 0), volume("new", 100, time.Minute), volume("old", 100, time.Hour)},
+			wantRemoved: []string{"old"},
+			wantFits:    true,
+		},
+		{
+			name:        "in use skipped",
+			config:      BuildCacheConfig{MaxTotalBytes: 200},
+			volumes:     []cacheVolume{volume("mounted", 100, 0), volume("new", 100, time.Minute), volume("old", 100, time.Hour)},
+			inUse:       []string{"old"},
+			wantRemoved: []string{"new"},
+			wantFits:    true,
+		},
+		{
+			name:     "no limits",
+			volumes:  []cacheVolume{volume("a", 1<<40, time.Hour), volume("mounted", 1<<40, 0)},
+			wantFits: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &buildCaches{config: tt.config, lastUsed: map[string]time.Time{}}
+			removed := []string{}
+			fits := c.evictVolumes(tt.volumes, "mounted", func(volume string) error {
+				if slices.Contains(tt.inUse, volume) {
+					return errInUse
+				}
+				removed = append(removed, volume)
+				return nil
+			})
+			if fits != tt.wantFits {
+				t.Errorf("fits = %v, want %v", fits, tt.wantFits)
+			}
+			if tt.wantRemoved == nil {
+				tt.wantRemoved = []string{}
+			}
+			if !slices.Equal(removed, tt.wantRemoved) {
+				t.Errorf("removed %q, want %q", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestBuildCacheEnabled(t *testing.T) {
+	cached := Language{BuildCommand: "cargo build", BuildCache: "/app/target"}
+	tests := []struct {
+		name     string
+		caches   *buildCaches
+		language Language
+		want     bool
+	}{
+		{"disabled", nil, cached, false},
+		{"cached", &buildCaches{}, cached, true},
+		{"no cache", &buildCaches{}, Language{BuildCommand: "cargo build"}, false},
+		{"no build", &buildCaches{}, Language{BuildCache: "/app/target"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.caches.enabled(tt.language); got != tt.want {
+				t.Errorf("enabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,94 +1,259 @@
 package driver
 
 import (
-	"archive/tar"
-	"bytes"
 	"context"
+	"errors"
+	"io"
+	"path"
+	"slices"
+	"strings"
 
 	"github.com/charmbracelet/log"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/stdcopy"
 )
 
 type CodeReq struct {
-	Code     *string `json:"code"`
-	Language *string `json:"language"`
+	Code       *string           `json:"code"` // Single file, named after the language
+	Files      []CodeFile        `json:"files"`
+	Entrypoint *string           `json:"entrypoint"` // File to run, defaults to the file name of the language
+	Manifest   *string           `json:"manifest"`   // Dependency manifest, named after the manifest of the language
+	Language   *string           `json:"language"`
+	Stdin      *string           `json:"stdin"` // Sent to the program before closing its input
+	Args       []string          `json:"args"`  // Arguments of the program
+	Env        map[string]string `json:"env"`   // Variables of the program, some names are denied
 }
 
-/*
-Right now executions are not interactive, this can be changed by attach the stdio to the container the same
-way we do on console.go
-*/
-func HandleExecution(ctx context.Context, code *CodeReq) ([]byte, error) {
-	log.Info("[models.HandleExecution] Code: \"", *code.Code, "\" ,Language: \"", *code.Language, "\"")
-	switch *code.Language {
-	case "rust":
-		return HandleGenericExecution(ctx, *code.Code, "/usr/local/cargo/bin/cargo run", "customrust", "latest", "/usr/src/app/devcontainer/src", "main.rs")
-	case "python":
-		return HandleGenericExecution(ctx, *code.Code, "python3 /app/main.py", "custompython", "latest", "/app", "main.py")
-	case "c":
-		return HandleGenericExecution(ctx, *code.Code, "./run.sh", "customc", "latest", "/app", "main.c")
-	case "cpp":
-		return HandleGenericExecution(ctx, *code.Code, "./runcpp.sh", "customcpp", "latest", "/app", "main.cpp")
-	case "typescript":
-		return HandleGenericExecution(ctx, *code.Code, "ts-node /app/index.ts", "customts", "latest", "/app", "index.ts")
-	case "go":
-		return HandleGenericExecution(ctx, *code.Code, "go run /app/main.go", "customgo", "latest", "/app", "main.go")
-	case "bash":
-		return HandleGenericExecution(ctx, *code.Code, "bash /app/main.sh", "custombash", "latest", "/app", "main.sh")
-	default:
-		return nil, nil
+// How to build and run the code of a language, languages are registered in the database
+type Language struct {
+	Name         string `json:"name"` // Identifier used in code requests
+	DisplayName  string `json:"display_name"`
+	Version      string `json:"version"`
+	Image        string `json:"image"`
+	Tag          string `json:"tag"`
+	Path         string `json:"path"`          // Directory the project is copied to, the working directory
+	Filename     string `json:"filename"`      // Default entrypoint, `$ENTRYPOINT` in the commands is its full path
+	BuildCommand string `json:"build_command"` // Optional, run before the run command
+	RunCommand   string `json:"run_command"`
+	Enabled      bool   `json:"enabled"`
+	Diagnostics  string `json:"diagnostics"` // Parser of the compiler output, empty for none
+	// Dependencies are installed when the project has this file, e.g. requirements.txt
+	Manifest       string `json:"manifest"`
+	InstallCommand string `json:"install_command"` // Installs the dependencies in the project, with network
+	CachePath      string `json:"cache_path"`      // Cache of the package manager, kept in a volume
+	// Build outputs or compiler cache, e.g. the cargo target directory or GOCACHE, kept in a volume
+	// between the builds
+	BuildCache string `json:"build_cache"`
+}
+
+// Shell command that builds and runs the code. The content of `markerFile` is printed to stderr
+// between the build and the run, so the compiler output can be told apart from the program's. The
+// build runs without the variables of the program, see `programEnvVar`.
+func (l Language) command() string {
+	if l.BuildCommand == "" {
+		return l.RunCommand
+	}
+	return "(unset $" + programEnvVar + "; " + l.BuildCommand + ") && cat " + markerFile + " >&2 && " + l.RunCommand
+}
+
+func (l Language) image() string {
+	return l.Image + ":" + l.Tag
+}
+
+// Same language, running from an image committed from one of its containers
+func (l Language) committed(reference string) Language {
+	l.Image, l.Tag, _ = strings.Cut(reference, ":")
+	return l
+}
+
+// Copy the files of the project and the build marker to the container. An empty marker prints
+// nothing.
+func copyProject(ctx context.Context, wc *WebContainer, language Language, project Project, marker string) error {
+	buf, errTar := createTar(project.Files)
+	if errTar != nil {
+		return errTar
+	}
+	if errCopy := wc.CopyFiles(ctx, language.Path, buf); errCopy != nil {
+		return errCopy
+	}
+	markerTar, errTar := createTar([]CodeFile{{Path: path.Base(markerFile), Content: marker}})
+	if errTar != nil {
+		return errTar
+	}
+	return wc.CopyFiles(ctx, path.Dir(markerFile), markerTar)
+}
+
+// Options of an execution besides the code
+type ExecutionOptions struct {
+	Stdin    io.Reader // Sent to the program, which then reads EOF. nil to close stdin right away.
+	Limits   ExecutionLimits
+	SkipPool bool // Create a new container even if the pool has one
+	Network  bool // Enable the network, pooled containers are never used with it
+	Env      []string
+	Mounts   []mount.Mount
+	User     string // Owner of the execution, their build cache of the language is mounted
+	// Called once the program exited, before the container is removed
+	OnExit func(containerID string, result *ExecutionResult) error
+}
+
+// Run the code and return its output once it exits. See RunInteractive to attach a client to stdin.
+func HandleExecution(ctx context.Context, language Language, project Project, options ExecutionOptions) (*ExecutionResult, error) {
+	log.Info("[models.HandleExecution]", "language", language.Name, "files", len(project.Files), "entrypoint", project.Entrypoint)
+	output := &outputCollector{}
+	result, errExec := runWithDependencies(ctx, language, project, options, output.handle)
+	if errExec != nil {
+		return nil, errExec
+	}
+	output.fill(result)
+	return result, nil
+}
+
+// Reader of the stdin of the request, nil if there's none
+func (code *CodeReq) StdinReader() io.Reader {
+	if code.Stdin == nil {
+		return nil
 	}
+	return strings.NewReader(*code.Stdin)
 }
 
 /*
 General process to execute code will be:
 1. Create the container with the corresponding language
-2. Copy the tmp file into the container (equivalent of doing docker cp <path> <container>:<path>)
-3. Start the container
-4. Get the out (logs) of the container (equivalent of doing docker logs <container>)
-5. Send it back to the client
+2. Copy the files of the project into the container (equivalent of doing docker cp <path> <container>:<path>)
+3. Attach to the container and start it
+4. Pass the output to `handler` as it's produced, stdout and stderr are kept apart
+5. Wait for the container to exit and inspect it for the result
 */
 
-func HandleGenericExecution(ctx context.Context, content string, command string, image string, tag string, filepath string, name string) ([]byte, error) {
-	wc := &WebContainer{
-		Command:       command,
-		Image:         ImageType(image + ":" + tag),
-		AttachIO:      true,
-		AutoRemove:    false,
-		Name:          nil,
-		Id:            nil,
-		NetworkEnable: false,
+// Run the code, passing its output to `handler`. The program is killed once it exceeds a limit of
+// `options.Limits` and the reason is set in the result.
+func HandleGenericExecution(ctx context.Context, language Language, project Project, options ExecutionOptions, handler OutputHandler) (*ExecutionResult, error) {
+	if errImage := EnsureImage(ctx, language.image()); errImage != nil {
+		return nil, errImage
+	}
+	limits := options.Limits
+	cache, errCache := buildCache.mount(ctx, language, options.User)
+	if errCache != nil {
+		return nil, errCache
+	}
+	if cache != nil {
+		options.Mounts = append(slices.Clip(options.Mounts), *cache)
+	}
+	// Pooled containers are already created, a miss falls back to creating one. Languages with a build
+	// cache never use them, even when the cache isn't mounted, so they aren't pooled.
+	var wc *WebContainer
+	if !options.SkipPool && !options.Network && len(options.Env) == 0 && len(options.Mounts) == 0 &&
+		project.poolable(language) && !buildCache.enabled(language) {
+		wc = codePool.claim(ctx, language, limits)
 	}
-	_, errCreate := wc.Create(ctx)
-	defer wc.RemoveContainer(ctx)
+	var errCreate error
+	if wc == nil {
+		wc = newCodeContainer(language, project, options)
+		_, errCreate = wc.Create(ctx)
+	}
+	// The context might be cancelled, the container must still be removed
+	defer wc.RemoveContainer(context.Background())
 	if errCreate != nil {
 		return nil, errCreate
 	}
 
-	buf, errTar := createTar(content, name)
-	if errTar != nil {
-		return nil, errTar
+	// The marker is random so the build can't print it to pass its output as the program's
+	marker := ""
+	if language.BuildCommand != "" {
+		id, errID := newSessionID()
+		if errID != nil {
+			return nil, errID
+		}
+		marker = "webconsole-build-" + id + "\n"
+	}
+	if errCopy := copyProject(ctx, wc, language, project, marker); errCopy != nil {
+		return nil, errCopy
+	}
+	// Attach before starting so no output is missed
+	stream, errAttach := wc.AttachContainer(ctx, AttachConfig{Stdin: true})
+	if errAttach != nil {
+		return nil, errAttach
+	}
+	defer stream.Close()
+	// The timeout starts with the program, creating the container and copying the project don't
+	// count against it
+	runCtx := ctx
+	if limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+	}
+	// The hijacked connection isn't bound to the context, a cancelled execution must stop reading
+	stopClose := context.AfterFunc(runCtx, stream.Close)
+	defer stopClose()
+	if errStart := wc.Start(runCtx); errStart != nil {
+		return nil, errStart
+	}
+	if options.Stdin != nil {
+		go func() {
+			if _, errWrite := io.Copy(stream.Conn, options.Stdin); errWrite != nil {
+				log.Warn("[driver.HandleGenericExecution] Error while writing stdin", "error", errWrite)
+			}
+			stream.CloseWrite()
+		}()
+	} else {
+		stream.CloseWrite()
+	}
+
+	result := &ExecutionResult{}
+	kill := func() {
+		dockerClient.ContainerKill(context.Background(), *wc.Id, "KILL")
+	}
+	limiter := &outputLimiter{handler: handler, limit: limits.MaxOutputBytes, result: result, exceeded: kill}
+	// The stderr of the build is kept to parse the diagnostics of the compiler
+	build := &buildSplitter{handler: limiter.handle, marker: []byte(marker)}
+	stdout := &outputWriter{stream: StreamStdout, handler: build.handle}
+	stderr := &outputWriter{stream: StreamStderr, handler: build.handle}
+	_, errCopy := stdcopy.StdCopy(stdout, stderr, stream.Reader)
+	stdout.flush()
+	stderr.flush()
+	build.flush()
+
+	if errCtx := runCtx.Err(); errCtx != nil {
+		kill()
+		if !errors.Is(errCtx, context.DeadlineExceeded) {
+			return nil, errCtx
+		}
+		result.TimedOut = true
+		result.KilledReason = KilledTimeout
+	} else if errCopy != nil {
+		log.Error("[driver.HandleGenericExecution] Error while reading the output", "error", errCopy)
+		return nil, errCopy
 	}
 
-	bufLogs, errCpRun := wc.CopyFileAndStart(ctx, filepath, buf)
-	if errCpRun != nil {
-		log.Error("[models.HandleGenericExecution] Error while copying and running: ", errCpRun)
-		return nil, errCpRun
+	if errInspect := inspectResult(*wc.Id, result); errInspect != nil {
+		return nil, errInspect
 	}
-	return bufLogs, nil
+	result.Diagnostics = parseDiagnostics(language, string(build.output))
+	if options.OnExit != nil {
+		if errExit := options.OnExit(*wc.Id, result); errExit != nil {
+			return nil, errExit
+		}
+	}
+	return result, nil
 }
 
-func createTar(content string, name string) (*bytes.Buffer, error) {
-	var buf bytes.Buffer
-	tw := tar.NewWriter(&buf)
-	err := tw.WriteHeader(&tar.Header{
-		Name: name,
-		Mode: 0777,
-		Size: int64(len(content)),
-	})
-	if err != nil {
-		return nil, err
-	}
-	tw.Write([]byte(content))
-	tw.Close()
-	return &buf, nil
+// Container running a project without a tty. Stdin is always open so the same container can be used
+// with or without input, the program reads EOF once it's closed.
+func newCodeContainer(language Language, project Project, options ExecutionOptions) *WebContainer {
+	limits := options.Limits
+	return &WebContainer{
+		Command:       "/bin/sh -c " + timedCommand(language.command()),
+		Args:          programArgs(project),
+		Image:         ImageType(language.image()),
+		AttachIO:      false, // Without a tty docker keeps stdout and stderr apart
+		AutoRemove:    false,
+		Name:          nil,
+		Id:            nil,
+		NetworkEnable: options.Network,
+		StdinOnce:     true,
+		Limits:        &limits,
+		Env:           containerEnv(language, project, options.Env),
+		Mounts:        options.Mounts,
+	}
 }
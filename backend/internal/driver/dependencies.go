@@ -0,0 +1,117 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/docker/docker/api/types/mount"
+)
+
+const (
+	DefaultInstallTimeout = 5 * time.Minute
+	// Prefix of the cache volumes of the package managers, followed by the name of the language
+	cacheVolumePrefix = "webconsole-cache-"
+)
+
+// Configuration of the dependency installs
+type DependencyConfig struct {
+	Env     []string      // Set for the install command, e.g. `PIP_INDEX_URL` to use a package mirror
+	Timeout time.Duration // Replaces the timeout of the user for the install
+}
+
+var dependencyConfig = DependencyConfig{Timeout: DefaultInstallTimeout}
+
+// Init the configuration of the dependency installs, must be call on server initialization
+func InitDependencies(config DependencyConfig) {
+	dependencyConfig = config
+}
+
+func needsInstall(language Language, project Project) bool {
+	return language.InstallCommand != "" && project.hasFile(language.Manifest)
+}
+
+func (p Project) hasFile(name string) bool {
+	for _, file := range p.Files {
+		if file.Path == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Install the dependencies of the project if it has the manifest of the language. The install
+// command runs with network and the cache volume of the language, then the container is committed
+// to an image the project runs from, without network.
+//
+// Returns the language to run the project with, the result of the install, nil if there was nothing
+// to install, and a function removing the image. The language is unchanged if the install failed.
+func installDependencies(ctx context.Context, language Language, project Project, limits ExecutionLimits) (Language, *ExecutionResult, func(), error) {
+	noop := func() {}
+	if !needsInstall(language, project) {
+		return language, nil, noop, nil
+	}
+	install := language
+	install.BuildCommand = ""
+	install.RunCommand = language.InstallCommand
+	install.Diagnostics = ""
+	options := ExecutionOptions{
+		Limits:  limits,
+		Network: true,
+		Env:     dependencyConfig.Env,
+	}
+	if dependencyConfig.Timeout > 0 {
+		options.Limits.Timeout = dependencyConfig.Timeout
+	}
+	if language.CachePath != "" {
+		options.Mounts = []mount.Mount{{
+			Type:   mount.TypeVolume,
+			Source: cacheVolumePrefix + language.Name,
+			Target: language.CachePath,
+		}}
+	}
+	reference := ""
+	options.OnExit = func(containerID string, result *ExecutionResult) error {
+		if result.failed() {
+			return nil
+		}
+		var errCommit error
+		reference, errCommit = commitContainer(ctx, containerID)
+		return errCommit
+	}
+
+	output := &outputCollector{}
+	// The arguments and variables are the program's, the install doesn't get them
+	result, errExec := HandleGenericExecution(ctx, install, project.withoutProgramEnv(), options, output.handle)
+	if errExec != nil {
+		log.Error("[driver.installDependencies] Error while installing the dependencies", "error", errExec)
+		return language, nil, noop, errExec
+	}
+	output.fill(result)
+	if reference == "" {
+		return language, result, noop, nil
+	}
+	return language.committed(reference), result, func() { removeImage(reference) }, nil
+}
+
+// Install the dependencies of the project, then run it. If the install fails the project isn't run
+// and the result only has the install.
+func runWithDependencies(ctx context.Context, language Language, project Project, options ExecutionOptions, handler OutputHandler) (*ExecutionResult, error) {
+	run, install, cleanup, errInstall := installDependencies(ctx, language, project, options.Limits)
+	if errInstall != nil {
+		return nil, errInstall
+	}
+	defer cleanup()
+	if install != nil && install.failed() {
+		return &ExecutionResult{ExitCode: install.ExitCode, KilledReason: install.KilledReason, Install: install}, nil
+	}
+	if run != language {
+		options.SkipPool = true
+	}
+	result, errExec := HandleGenericExecution(ctx, run, project, options, handler)
+	if errExec != nil {
+		return nil, errExec
+	}
+	result.Install = install
+	return result, nil
+}
@@ -0,0 +1,206 @@
+package driver
+
+import (
+	"bytes"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const maxDiagnostics = 200
+
+// Error or warning of a compiler, `File` is relative to the source directory of the language
+type Diagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"` // 0 if the compiler doesn't report it
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Code     string `json:"code,omitempty"` // Error code or warning flag, e.g. E0425, TS2304 or -Wunused-variable
+}
+
+// Parsers of compiler output, by the name used in the `diagnostics` field of languages
+var diagnosticParsers = map[string]func(output string) []Diagnostic{
+	"gcc":   parseGCC,
+	"go":    parseGo,
+	"rustc": parseRustc,
+	"tsc":   parseTSC,
+}
+
+// An empty name is valid and disables the diagnostics
+func IsDiagnosticParser(name string) bool {
+	_, ok := diagnosticParsers[name]
+	return ok || name == ""
+}
+
+// Parse the compiler output of the language, nil if the language has no parser
+func parseDiagnostics(language Language, output string) []Diagnostic {
+	parser, ok := diagnosticParsers[language.Diagnostics]
+	if !ok {
+		return nil
+	}
+	diagnostics := parser(output)
+	if len(diagnostics) > maxDiagnostics {
+		diagnostics = diagnostics[:maxDiagnostics]
+	}
+	for i := range diagnostics {
+		file := strings.TrimPrefix(diagnostics[i].File, strings.TrimSuffix(language.Path, "/")+"/")
+		diagnostics[i].File = path.Clean(file)
+	}
+	return diagnostics
+}
+
+// Splits the output of an execution at the marker printed to stderr between the build and the run.
+// The output before the marker is the compiler output, stdout included since some compilers like
+// tsc report there, and the marker itself is removed. Without a marker nothing is build output.
+type buildSplitter struct {
+	handler OutputHandler
+	marker  []byte
+	output  []byte // Output of the build
+	held    []byte // End of the stderr that might be the start of the marker
+	done    bool   // The marker was found, the rest is the program's
+}
+
+func (b *buildSplitter) handle(stream OutputStream, data []byte) {
+	if b.done || len(b.marker) == 0 {
+		b.handler(stream, data)
+		return
+	}
+	if stream != StreamStderr {
+		b.build(stream, data)
+		return
+	}
+	data = append(b.held, data...)
+	b.held = nil
+	if i := bytes.Index(data, b.marker); i >= 0 {
+		b.done = true
+		b.build(stream, data[:i])
+		if rest := data[i+len(b.marker):]; len(rest) > 0 {
+			b.handler(stream, rest)
+		}
+		return
+	}
+	// The marker is ASCII, holding back part of it never splits a UTF-8 sequence
+	keep := 0
+	for n := min(len(data), len(b.marker)-1); n > 0; n-- {
+		if bytes.HasSuffix(data, b.marker[:n]) {
+			keep = n
+			break
+		}
+	}
+	b.build(stream, data[:len(data)-keep])
+	b.held = bytes.Clone(data[len(data)-keep:])
+}
+
+func (b *buildSplitter) build(stream OutputStream, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	b.output = append(b.output, data...)
+	b.handler(stream, data)
+}
+
+// Send the held back stderr, the marker won't come
+func (b *buildSplitter) flush() {
+	held := b.held
+	b.held = nil
+	b.build(StreamStderr, held)
+}
+
+var gccRegex = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)? (fatal error|error|warning|note): (.*?)(?: \[(-W[^\]]+)\])?$`)
+
+// `main.c:3:5: error: message [-Wflag]`, the column is missing on old versions
+func parseGCC(output string) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	for _, line := range strings.Split(output, "\n") {
+		match := gccRegex.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if match == nil {
+			continue
+		}
+		severity := match[4]
+		if severity == "fatal error" {
+			severity = "error"
+		}
+		diagnostics = append(diagnostics, newDiagnostic(match[1], match[2], match[3], severity, match[5], match[6]))
+	}
+	return diagnostics
+}
+
+var goRegex = regexp.MustCompile(`^(.+?\.go):(\d+):(?:(\d+):)? (.*)$`)
+
+// `./main.go:5:2: message`, every diagnostic of the go command is an error
+func parseGo(output string) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	for _, line := range strings.Split(output, "\n") {
+		match := goRegex.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if match == nil {
+			continue
+		}
+		diagnostics = append(diagnostics, newDiagnostic(match[1], match[2], match[3], "error", match[4], ""))
+	}
+	return diagnostics
+}
+
+var (
+	rustcHeaderRegex   = regexp.MustCompile(`^(error|warning)(?:\[(\w+)\])?: (.*)$`)
+	rustcLocationRegex = regexp.MustCompile(`^\s*--> (.+?):(\d+):(\d+)$`)
+)
+
+// Messages span several lines:
+//
+//	error[E0425]: cannot find value `x` in this scope
+//	 --> src/main.rs:2:20
+//
+// Summaries such as `error: could not compile` have no location and are skipped.
+func parseRustc(output string) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	var header []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if match := rustcHeaderRegex.FindStringSubmatch(line); match != nil {
+			header = match
+			continue
+		}
+		if match := rustcLocationRegex.FindStringSubmatch(line); match != nil && header != nil {
+			diagnostics = append(diagnostics, newDiagnostic(match[1], match[2], match[3], header[1], header[3], header[2]))
+			header = nil
+		}
+	}
+	return diagnostics
+}
+
+var (
+	tscRegex    = regexp.MustCompile(`^(.+?)\((\d+),(\d+)\): (error|warning) (TS\d+): (.*)$`)
+	tsNodeRegex = regexp.MustCompile(`^(.+?):(\d+):(\d+) - (error|warning) (TS\d+): (.*)$`)
+)
+
+// `index.ts(3,5): error TS2304: message` for tsc, `index.ts:3:5 - error TS2304: message` for ts-node
+func parseTSC(output string) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		match := tscRegex.FindStringSubmatch(line)
+		if match == nil {
+			match = tsNodeRegex.FindStringSubmatch(line)
+		}
+		if match == nil {
+			continue
+		}
+		diagnostics = append(diagnostics, newDiagnostic(match[1], match[2], match[3], match[4], match[6], match[5]))
+	}
+	return diagnostics
+}
+
+func newDiagnostic(file string, line string, column string, severity string, message string, code string) Diagnostic {
+	lineNumber, _ := strconv.Atoi(line)
+	columnNumber, _ := strconv.Atoi(column)
+	return Diagnostic{
+		File:     file,
+		Line:     lineNumber,
+		Column:   columnNumber,
+		Severity: severity,
+		Message:  message,
+		Code:     code,
+	}
+}
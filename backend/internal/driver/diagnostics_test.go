@@ -0,0 +1,96 @@
+package driver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	tests := []struct {
+		name   string
+		parser string
+		output string
+		want   []Diagnostic
+	}{
+		{"gcc", "gcc", "/app/main.c: In function 'main':\n/app/main.c:3:5: error: 'x' undeclared\n/app/main.c:4:9: warning: unused variable 'y' [-Wunused-variable]\n",
+			[]Diagnostic{
+				{File: "main.c", Line: 3, Column: 5, Severity: "error", Message: "'x' undeclared"},
+				{File: "main.c", Line: 4, Column: 9, Severity: "warning", Message: "unused variable 'y'", Code: "-Wunused-variable"},
+			}},
+		{"gcc without column", "gcc", "./src/lib.c:12: fatal error: lib.h: No such file\r\n",
+			[]Diagnostic{{File: "src/lib.c", Line: 12, Severity: "error", Message: "lib.h: No such file"}}},
+		{"go", "go", "# app\n./main.go:5:2: undefined: x\n",
+			[]Diagnostic{{File: "main.go", Line: 5, Column: 2, Severity: "error", Message: "undefined: x"}}},
+		{"rustc", "rustc", "error[E0425]: cannot find value `x` in this scope\n --> src/main.rs:2:20\n  |\nwarning: unused variable: `y`\n --> src/main.rs:3:9\nerror: could not compile `devcontainer`\n",
+			[]Diagnostic{
+				{File: "src/main.rs", Line: 2, Column: 20, Severity: "error", Message: "cannot find value `x` in this scope", Code: "E0425"},
+				{File: "src/main.rs", Line: 3, Column: 9, Severity: "warning", Message: "unused variable: `y`"},
+			}},
+		{"tsc", "tsc", "/app/index.ts(3,5): error TS2304: Cannot find name 'x'.\n",
+			[]Diagnostic{{File: "index.ts", Line: 3, Column: 5, Severity: "error", Message: "Cannot find name 'x'.", Code: "TS2304"}}},
+		{"ts-node", "tsc", "index.ts:1:7 - error TS2322: Type 'string' is not assignable to type 'number'.\n",
+			[]Diagnostic{{File: "index.ts", Line: 1, Column: 7, Severity: "error", Message: "Type 'string' is not assignable to type 'number'.", Code: "TS2322"}}},
+		{"no diagnostics", "gcc", "collect2: error: ld returned 1 exit status\n", []Diagnostic{}},
+		{"no parser", "", "/app/main.c:3:5: error: x\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			language := Language{Path: "/app/", Diagnostics: tt.parser}
+			if got := parseDiagnostics(language, tt.output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDiagnostics() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildSplitter(t *testing.T) {
+	const marker = "webconsole-build-0123\n"
+	type chunk struct {
+		stream OutputStream
+		data   string
+	}
+	tests := []struct {
+		name       string
+		marker     string
+		chunks     []chunk
+		wantBuild  string
+		wantStdout string
+		wantStderr string
+	}{
+		{"no marker configured", "", []chunk{{StreamStderr, "warning\n"}, {StreamStdout, "out"}},
+			"", "out", "warning\n"},
+		{"build and run", marker, []chunk{{StreamStderr, "warning\n" + marker + "panic\n"}},
+			"warning\n", "", "warning\npanic\n"},
+		{"marker split across chunks", marker, []chunk{{StreamStderr, "warning\nwebconsole-"}, {StreamStderr, "build-0123\npanic\n"}},
+			"warning\n", "", "warning\npanic\n"},
+		{"marker after a partial line", marker, []chunk{{StreamStderr, "no newline" + marker}},
+			"no newline", "", "no newline"},
+		{"build stdout", marker, []chunk{{StreamStdout, "index.ts(1,1): error\n"}, {StreamStderr, marker}, {StreamStdout, "hello\n"}},
+			"index.ts(1,1): error\n", "index.ts(1,1): error\nhello\n", ""},
+		{"failed build", marker, []chunk{{StreamStderr, "error\nwebconsole-bu"}},
+			"error\nwebconsole-bu", "", "error\nwebconsole-bu"},
+		{"program prints the marker", marker, []chunk{{StreamStderr, marker + marker}},
+			"", "", marker},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			splitter := &buildSplitter{marker: []byte(tt.marker), handler: func(stream OutputStream, data []byte) {
+				if stream == StreamStdout {
+					stdout.Write(data)
+				} else {
+					stderr.Write(data)
+				}
+			}}
+			for _, c := range tt.chunks {
+				splitter.handle(c.stream, []byte(c.data))
+			}
+			splitter.flush()
+			if string(splitter.output) != tt.wantBuild || stdout.String() != tt.wantStdout || stderr.String() != tt.wantStderr {
+				t.Errorf("build %q, stdout %q, stderr %q, want %q, %q, %q",
+					splitter.output, stdout.String(), stderr.String(), tt.wantBuild, tt.wantStdout, tt.wantStderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package driver
+
+import (
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	MaxProgramArgs = 256
+	MaxProgramEnv  = 64
+	MaxArgsBytes   = 64 * 1024 // Arguments and variables of a program together
+)
+
+// Variables the program can't set, they would break the commands of the languages or change how a
+// toolchain run by the run command builds. Names ending with `*` are prefixes, names are matched
+// ignoring case.
+var defaultEnvDenylist = []string{
+	"ENTRYPOINT", "PATH", "HOME", "IFS", "ENV", "BASH_ENV", "SHELLOPTS", "BASHOPTS", "PS4", "CDPATH",
+	"BASH_FUNC_*", "PYTHONPATH", "LD_*", "GOFLAGS", "GOCACHE", "GOPATH", "RUSTFLAGS", "RUSTC",
+	"RUSTC_WRAPPER", "RUSTDOCFLAGS", "CARGO_*", "PIP_*", "NPM_CONFIG_*", "NODE_OPTIONS", "TS_NODE_*",
+	"WEBCONSOLE_*",
+}
+
+// Names of the variables of the program, space separated. The build step unsets them so only the run
+// step sees them.
+const programEnvVar = "WEBCONSOLE_PROGRAM_ENV"
+
+var (
+	envDenylist  = defaultEnvDenylist
+	envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Add names to the denylist of program variables, must be call on server initialization
+func InitEnvDenylist(names []string) {
+	envDenylist = append(append([]string{}, defaultEnvDenylist...), names...)
+}
+
+func isDeniedEnv(name string) bool {
+	for _, denied := range envDenylist {
+		if prefix, ok := strings.CutSuffix(denied, "*"); ok && len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+			return true
+		}
+		if strings.EqualFold(name, denied) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the arguments and variables of the program fit in the limits and no variable is denied
+func (p *Project) validateArgs() bool {
+	if len(p.Args) > MaxProgramArgs || len(p.Env) > MaxProgramEnv {
+		return false
+	}
+	// A null byte can't be in an argument or a variable
+	size := 0
+	for _, arg := range p.Args {
+		if strings.ContainsRune(arg, 0) {
+			return false
+		}
+		size += len(arg)
+	}
+	for name, value := range p.Env {
+		if !envNameRegex.MatchString(name) || isDeniedEnv(name) || strings.ContainsRune(value, 0) {
+			return false
+		}
+		size += len(name) + len(value)
+	}
+	return size <= MaxArgsBytes
+}
+
+// Variables of the container running the project: `env`, then `ENTRYPOINT`, the variables of the
+// program and `programEnvVar` with their names, so the build can unset them. Docker sets them, they
+// never go through a shell.
+func containerEnv(language Language, project Project, env []string) []string {
+	names := make([]string, 0, len(project.Env))
+	for name := range project.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	vars := append(slices.Clip(env), "ENTRYPOINT="+path.Join(language.Path, project.Entrypoint))
+	for _, name := range names {
+		vars = append(vars, name+"="+project.Env[name])
+	}
+	return append(vars, programEnvVar+"="+strings.Join(names, " "))
+}
+
+// Arguments of the `/bin/sh -c` command running the project: `$0`, then the arguments the run
+// command gets as `"$@"`
+func programArgs(project Project) []string {
+	return append([]string{"sh"}, project.Args...)
+}
+
+// Whether the project can run in a pooled container, which are created with the default entrypoint
+// of the language and without arguments or variables
+func (p Project) poolable(language Language) bool {
+	return p.Entrypoint == language.Filename && len(p.Args) == 0 && len(p.Env) == 0
+}
+
+// The project without the arguments and variables of the program, for the steps that aren't the
+// program: dependency installs and builds
+func (p Project) withoutProgramEnv() Project {
+	p.Args = nil
+	p.Env = nil
+	return p
+}
@@ -0,0 +1,120 @@
+package driver
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestIsDeniedEnv(t *testing.T) {
+	tests := []struct {
+		name   string
+		denied bool
+	}{
+		{"DEBUG", false},
+		{"MY_PATH", false},
+		{"CARGO", false},
+		{"PATH", true},
+		{"path", true},
+		{"LD_PRELOAD", true},
+		{"ld_library_path", true},
+		{"RUSTFLAGS", true},
+		{"RUSTC_WRAPPER", true},
+		{"CARGO_HOME", true},
+		{"GOFLAGS", true},
+		{"PIP_INDEX_URL", true},
+		{"npm_config_registry", true},
+		{"BASH_FUNC_foo%%", true},
+		{"WEBCONSOLE_PROGRAM_ENV", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isDeniedEnv(tt.name); got != tt.denied {
+				t.Errorf("isDeniedEnv(%q) = %v, want %v", tt.name, got, tt.denied)
+			}
+		})
+	}
+}
+
+func TestValidateArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		project Project
+		ok      bool
+	}{
+		{"empty", Project{}, true},
+		{"args and env", Project{Args: []string{"a b", "'$(id)'", ""}, Env: map[string]string{"DEBUG": "1; rm -rf /"}}, true},
+		{"null byte in arg", Project{Args: []string{"a\x00b"}}, false},
+		{"null byte in value", Project{Env: map[string]string{"DEBUG": "\x00"}}, false},
+		{"invalid name", Project{Env: map[string]string{"A-B": "1"}}, false},
+		{"name with a digit first", Project{Env: map[string]string{"1A": "1"}}, false},
+		{"denied name", Project{Env: map[string]string{"RUSTFLAGS": "-C panic=abort"}}, false},
+		{"too many args", Project{Args: make([]string, MaxProgramArgs+1)}, false},
+		{"too big", Project{Args: []string{strings.Repeat("a", MaxArgsBytes+1)}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.project.validateArgs(); got != tt.ok {
+				t.Errorf("validateArgs() = %v, want %v", got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNewCodeContainer(t *testing.T) {
+	language := Language{Path: "/app", Filename: "main.py", BuildCommand: "build", RunCommand: `run "$ENTRYPOINT" "$@"`}
+	project := Project{
+		Entrypoint: "src/main.py",
+		Args:       []string{"a b", "$HOME"},
+		Env:        map[string]string{"B": "2 3", "A": "$(id)"},
+	}
+	wc := newCodeContainer(language, project, ExecutionOptions{Env: []string{"LANG=C"}})
+
+	wantArgs := []string{"sh", "a b", "$HOME"}
+	if !slices.Equal(wc.Args, wantArgs) {
+		t.Errorf("Args = %q, want %q", wc.Args, wantArgs)
+	}
+	wantEnv := []string{"LANG=C", "ENTRYPOINT=/app/src/main.py", "A=$(id)", "B=2 3", programEnvVar + "=A B"}
+	if !slices.Equal(wc.Env, wantEnv) {
+		t.Errorf("Env = %q, want %q", wc.Env, wantEnv)
+	}
+	if !strings.HasPrefix(wc.Command, "/bin/sh -c (unset $"+programEnvVar+"; build) && ") {
+		t.Errorf("Command = %q, the build doesn't unset the variables of the program", wc.Command)
+	}
+	for _, value := range append(project.Args, project.Env["A"], project.Env["B"]) {
+		if strings.Contains(wc.Command, value) {
+			t.Errorf("Command = %q, contains %q", wc.Command, value)
+		}
+	}
+
+	// Installs and builds don't get the arguments and variables
+	install := newCodeContainer(language, project.withoutProgramEnv(), ExecutionOptions{})
+	if !slices.Equal(install.Args, []string{"sh"}) {
+		t.Errorf("install Args = %q, want only $0", install.Args)
+	}
+	wantEnv = []string{"ENTRYPOINT=/app/src/main.py", programEnvVar + "="}
+	if !slices.Equal(install.Env, wantEnv) {
+		t.Errorf("install Env = %q, want %q", install.Env, wantEnv)
+	}
+}
+
+func TestProjectPoolable(t *testing.T) {
+	language := Language{Filename: "main.py"}
+	tests := []struct {
+		name    string
+		project Project
+		want    bool
+	}{
+		{"default entrypoint", Project{Entrypoint: "main.py"}, true},
+		{"other entrypoint", Project{Entrypoint: "src/main.py"}, false},
+		{"args", Project{Entrypoint: "main.py", Args: []string{"a"}}, false},
+		{"env", Project{Entrypoint: "main.py", Env: map[string]string{"A": "1"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.project.poolable(language); got != tt.want {
+				t.Errorf("poolable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// Where exec processes store their pid inside the container, docker only knows the host pid
+const execPidDir = "/tmp"
+
+// How long a stopped exec process has to exit before it's killed
+const execStopGrace = 5 * time.Second
+
+// Process started with docker exec on a running container
+type execProcess struct {
+	containerID string
+	execID      string
+	pidFile     string
+}
+
+// Create and attach to a new exec process with a tty. The command is wrapped so it writes its pid to
+// a file before replacing itself with `cmd`, so it can be signaled later.
+func startExec(ctx context.Context, containerID string, sessionID string, cmd []string, width uint, height uint) (*execProcess, types.HijackedResponse, error) {
+	if len(cmd) == 0 {
+		return nil, types.HijackedResponse{}, errors.New("empty exec command")
+	}
+	pidFile := execPidDir + "/.webconsole-" + sessionID + ".pid"
+	wrapped := append([]string{"/bin/sh", "-c", `echo $$ > "$0"; exec "$@"`, pidFile}, cmd...)
+	consoleSize := &[2]uint{height, width}
+
+	execRes, errCreate := dockerClient.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Tty:          true,
+		ConsoleSize:  consoleSize,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          wrapped,
+	})
+	if errCreate != nil {
+		return nil, types.HijackedResponse{}, errCreate
+	}
+
+	stream, errAttach := dockerClient.ContainerExecAttach(ctx, execRes.ID, container.ExecAttachOptions{
+		Tty:         true,
+		ConsoleSize: consoleSize,
+	})
+	if errAttach != nil {
+		return nil, types.HijackedResponse{}, errAttach
+	}
+
+	return &execProcess{
+		containerID: containerID,
+		execID:      execRes.ID,
+		pidFile:     pidFile,
+	}, stream, nil
+}
+
+func (p *execProcess) Resize(ctx context.Context, width uint, height uint) error {
+	return dockerClient.ContainerExecResize(ctx, p.execID, container.ResizeOptions{
+		Height: height,
+		Width:  width,
+	})
+}
+
+// Docker has no way to wait for an exec, the process is over once its output stream is closed. Its
+// pid file is then removed, it's only needed to signal the process.
+func (p *execProcess) Wait(ctx context.Context, streamDone <-chan struct{}) (int64, error) {
+	select {
+	case <-streamDone:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	inspect, err := dockerClient.ContainerExecInspect(ctx, p.execID)
+	if err != nil {
+		return 0, err
+	}
+	if !inspect.Running {
+		// Fails if the container stopped meanwhile, nothing can signal the process then
+		if errRemove := p.run(ctx, `rm -f "$0"`); errRemove != nil {
+			log.Debug("[execProcess.Wait] Pid file not removed", "exec", p.execID, "error", errRemove)
+		}
+	}
+	return int64(inspect.ExitCode), nil
+}
+
+// Send SIGHUP to the process, like closing a terminal window would. Processes ignoring it are
+// killed after `execStopGrace`.
+func (p *execProcess) Stop(ctx context.Context) error {
+	if err := p.run(ctx, `kill -HUP "$(cat "$0")"`); err != nil {
+		return err
+	}
+	time.AfterFunc(execStopGrace, p.kill)
+	return nil
+}
+
+// Send SIGKILL to the process if it's still running and remove its pid file
+func (p *execProcess) kill() {
+	ctx, cancel := context.WithTimeout(context.Background(), execStopGrace)
+	defer cancel()
+	inspect, errInspect := dockerClient.ContainerExecInspect(ctx, p.execID)
+	if errInspect != nil {
+		log.Debug("[execProcess.kill] Exec process not found", "exec", p.execID, "error", errInspect)
+		return
+	}
+	script := `rm -f "$0"`
+	if inspect.Running {
+		log.Info("[execProcess.kill] Process didn't exit on SIGHUP, killing it", "exec", p.execID)
+		script = `kill -KILL "$(cat "$0")"; ` + script
+	}
+	if err := p.run(ctx, script); err != nil {
+		log.Error("[execProcess.kill] Error while killing the process", "exec", p.execID, "error", err)
+	}
+}
+
+// Run a shell script in the container without waiting for it, `$0` is the pid file of the process
+func (p *execProcess) run(ctx context.Context, script string) error {
+	execRes, errCreate := dockerClient.ContainerExecCreate(ctx, p.containerID, container.ExecOptions{
+		Cmd: []string{"/bin/sh", "-c", script, p.pidFile},
+	})
+	if errCreate != nil {
+		return errCreate
+	}
+	return dockerClient.ContainerExecStart(ctx, execRes.ID, container.ExecStartOptions{Detach: true})
+}
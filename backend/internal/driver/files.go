@@ -0,0 +1,223 @@
+package driver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	MaxProjectFiles = 512
+	MaxProjectBytes = 8 * 1024 * 1024 // Content of all the files of a project
+)
+
+var (
+	ErrInvalidProject     = errors.New("invalid project")
+	ErrUnsupportedArchive = errors.New("unsupported archive format")
+)
+
+// File of a project, `Path` is relative to the source directory of the language
+type CodeFile struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// Files materialized in the container before building and running the code
+type Project struct {
+	Files      []CodeFile
+	Entrypoint string            // Path of the file to run, relative to the source directory
+	Args       []string          // Arguments of the program, `"$@"` in the run command
+	Env        map[string]string // Variables of the program
+}
+
+// Build the project of a request: either the single `Code` file, named after the language, or the
+// `Files` tree. The entrypoint defaults to the file name of the language.
+func (code *CodeReq) Project(language Language) (Project, error) {
+	project := Project{Files: code.Files, Entrypoint: language.Filename, Args: code.Args, Env: code.Env}
+	if code.Code != nil {
+		project.Files = append([]CodeFile{{Path: language.Filename, Content: *code.Code}}, code.Files...)
+	}
+	if code.Manifest != nil {
+		if language.Manifest == "" {
+			return Project{}, ErrInvalidProject
+		}
+		project.Files = append(project.Files, CodeFile{Path: language.Manifest, Content: *code.Manifest})
+	}
+	if code.Entrypoint != nil {
+		project.Entrypoint = *code.Entrypoint
+	}
+	if errValidate := project.validate(); errValidate != nil {
+		return Project{}, errValidate
+	}
+	return project, nil
+}
+
+// Clean the paths and check the project, its arguments and its variables fit in the limits
+func (p *Project) validate() error {
+	if len(p.Files) == 0 || len(p.Files) > MaxProjectFiles {
+		return ErrInvalidProject
+	}
+	size := 0
+	seen := make(map[string]bool, len(p.Files))
+	for i, file := range p.Files {
+		cleaned, ok := cleanPath(file.Path)
+		if !ok || seen[cleaned] {
+			return ErrInvalidProject
+		}
+		seen[cleaned] = true
+		p.Files[i].Path = cleaned
+		size += len(file.Content)
+	}
+	entrypoint, ok := cleanPath(p.Entrypoint)
+	if size > MaxProjectBytes || !ok || !seen[entrypoint] || !p.validateArgs() {
+		return ErrInvalidProject
+	}
+	p.Entrypoint = entrypoint
+	return nil
+}
+
+// Relative path without `..` components, files can't be written outside the source directory
+func cleanPath(name string) (string, bool) {
+	cleaned := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if name == "" || path.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", false
+	}
+	return cleaned, true
+}
+
+// Read the files of an uploaded archive, the format is guessed from its name: `.zip`, `.tar`,
+// `.tar.gz` or `.tgz`. Directories and links are skipped.
+func ReadArchive(name string, reader io.Reader) ([]CodeFile, error) {
+	// One more byte to detect archives over the limit
+	content, errRead := io.ReadAll(io.LimitReader(reader, MaxProjectBytes+1))
+	if errRead != nil {
+		return nil, errRead
+	}
+	if len(content) > MaxProjectBytes {
+		return nil, ErrInvalidProject
+	}
+
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return readZip(content)
+	case strings.HasSuffix(name, ".tar"):
+		return readTar(bytes.NewReader(content))
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		gzipReader, errGzip := gzip.NewReader(bytes.NewReader(content))
+		if errGzip != nil {
+			return nil, errGzip
+		}
+		defer gzipReader.Close()
+		return readTar(gzipReader)
+	}
+	return nil, ErrUnsupportedArchive
+}
+
+func readZip(content []byte) ([]CodeFile, error) {
+	archive, errZip := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if errZip != nil {
+		return nil, errZip
+	}
+	files := []CodeFile{}
+	size := 0
+	for _, entry := range archive.File {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		if len(files) == MaxProjectFiles {
+			return nil, ErrInvalidProject
+		}
+		reader, errOpen := entry.Open()
+		if errOpen != nil {
+			return nil, errOpen
+		}
+		// The uncompressed size in the header can't be trusted
+		data, errRead := io.ReadAll(io.LimitReader(reader, int64(MaxProjectBytes-size+1)))
+		reader.Close()
+		if errRead != nil {
+			return nil, errRead
+		}
+		size += len(data)
+		if size > MaxProjectBytes {
+			return nil, ErrInvalidProject
+		}
+		files = append(files, CodeFile{Path: entry.Name, Content: string(data)})
+	}
+	return files, nil
+}
+
+func readTar(reader io.Reader) ([]CodeFile, error) {
+	archive := tar.NewReader(reader)
+	files := []CodeFile{}
+	size := 0
+	for {
+		header, errNext := archive.Next()
+		if errNext == io.EOF {
+			return files, nil
+		}
+		if errNext != nil {
+			return nil, errNext
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if len(files) == MaxProjectFiles {
+			return nil, ErrInvalidProject
+		}
+		data, errRead := io.ReadAll(io.LimitReader(archive, int64(MaxProjectBytes-size+1)))
+		if errRead != nil {
+			return nil, errRead
+		}
+		size += len(data)
+		if size > MaxProjectBytes {
+			return nil, ErrInvalidProject
+		}
+		files = append(files, CodeFile{Path: header.Name, Content: string(data)})
+	}
+}
+
+// Tar archive of the project, extracted in the source directory of the language
+func createTar(files []CodeFile) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	dirs := map[string]bool{}
+	// Build tools comparing modification times, like cargo, must see the files as newer than the
+	// outputs in the build cache
+	now := time.Now()
+	for _, file := range files {
+		// Parent directories first, so they are created with the right permissions
+		var parents []string
+		for dir := path.Dir(file.Path); dir != "." && !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+			parents = append([]string{dir}, parents...)
+		}
+		for _, dir := range parents {
+			if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0777, ModTime: now}); err != nil {
+				return nil, err
+			}
+		}
+		err := tw.WriteHeader(&tar.Header{
+			Name:    file.Path,
+			Mode:    0777,
+			Size:    int64(len(file.Content)),
+			ModTime: now,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write([]byte(file.Content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
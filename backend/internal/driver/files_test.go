@@ -0,0 +1,109 @@
+package driver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"testing"
+)
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"main.go", "main.go", true},
+		{"src/lib.rs", "src/lib.rs", true},
+		{"./src//lib.rs", "src/lib.rs", true},
+		{"src/../main.go", "main.go", true},
+		{`src\lib.rs`, "src/lib.rs", true},
+		{"..main.go", "..main.go", true},
+		{"", "", false},
+		{".", "", false},
+		{"src/..", "", false},
+		{"..", "", false},
+		{"../main.go", "", false},
+		{"src/../../main.go", "", false},
+		{`..\main.go`, "", false},
+		{"/etc/passwd", "", false},
+		{`\etc\passwd`, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := cleanPath(tt.name)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("cleanPath(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestReadArchive(t *testing.T) {
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	zw.Create("src/")
+	w, _ := zw.Create("src/main.go")
+	w.Write([]byte("package main"))
+	zw.Close()
+
+	var tarBuf bytes.Buffer
+	tw := tar.NewWriter(&tarBuf)
+	tw.WriteHeader(&tar.Header{Name: "src/", Typeflag: tar.TypeDir, Mode: 0o755})
+	tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"})
+	tw.WriteHeader(&tar.Header{Name: "src/main.go", Typeflag: tar.TypeReg, Mode: 0o644, Size: 12})
+	tw.Write([]byte("package main"))
+	tw.Close()
+
+	tests := []struct {
+		name    string
+		content []byte
+		err     error
+	}{
+		{"project.zip", zipBuf.Bytes(), nil},
+		{"project.TAR", tarBuf.Bytes(), nil},
+		{"project.rar", tarBuf.Bytes(), ErrUnsupportedArchive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := ReadArchive(tt.name, bytes.NewReader(tt.content))
+			if err != tt.err {
+				t.Fatalf("ReadArchive() error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			// Directories and links are skipped
+			if len(files) != 1 || files[0].Path != "src/main.go" || files[0].Content != "package main" {
+				t.Errorf("ReadArchive() = %+v", files)
+			}
+		})
+	}
+}
+
+func TestProjectValidatePaths(t *testing.T) {
+	tests := []struct {
+		name       string
+		paths      []string
+		entrypoint string
+		ok         bool
+	}{
+		{"cleaned", []string{"./main.go", "src//lib.go"}, "main.go", true},
+		{"entrypoint cleaned", []string{"src/main.go"}, "./src/main.go", true},
+		{"traversal", []string{"../main.go"}, "main.go", false},
+		{"duplicate after cleaning", []string{"main.go", "./main.go"}, "main.go", false},
+		{"missing entrypoint", []string{"lib.go"}, "main.go", false},
+		{"no files", nil, "main.go", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			project := Project{Entrypoint: tt.entrypoint}
+			for _, p := range tt.paths {
+				project.Files = append(project.Files, CodeFile{Path: p})
+			}
+			if err := project.validate(); (err == nil) != tt.ok {
+				t.Errorf("validate() = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
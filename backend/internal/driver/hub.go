@@ -0,0 +1,187 @@
+package driver
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Role of a participant of a console session
+type Role string
+
+const (
+	RoleOwner  Role = "owner"  // Owner of the container, can type and manage access
+	RoleDriver Role = "driver" // Can type and resize the terminal
+	RoleViewer Role = "viewer" // Read only
+)
+
+var (
+	ErrReadOnly      = errors.New("read only participant")
+	ErrAccessRevoked = errors.New("access to the session was revoked")
+	ErrInvalidRole   = errors.New("invalid role")
+)
+
+// Roles that can be granted to other users
+func (r Role) IsGrantable() bool {
+	return r == RoleDriver || r == RoleViewer
+}
+
+// If the role can send input to the session
+func (r Role) CanWrite() bool {
+	return r == RoleOwner || r == RoleDriver
+}
+
+// A web socket client attached to a session. Everything sent to the client goes through its queue,
+// so a slow client never blocks the session.
+type Client struct {
+	Email    string
+	IP       string
+	Role     Role
+	JoinedAt time.Time
+
+	conn       *ConsoleConn
+	queue      *outputQueue
+	writerDone chan struct{}
+}
+
+func newClient(email string, ip string, role Role, conn *ConsoleConn, config SessionConfig) *Client {
+	return &Client{
+		Email:      email,
+		IP:         ip,
+		Role:       role,
+		JoinedAt:   time.Now(),
+		conn:       conn,
+		queue:      newOutputQueue(config.ClientQueueBytes, config.SlowConsumer),
+		writerDone: make(chan struct{}),
+	}
+}
+
+// Write the queue to the web socket until the client is closed
+func (c *Client) writeLoop() {
+	defer close(c.writerDone)
+	c.queue.writeTo(c.conn)
+	c.conn.Close()
+}
+
+func (c *Client) send(msg *Message) {
+	c.queue.pushMessage(msg)
+}
+
+// Queue output for the client, disconnecting it if it's too slow
+func (c *Client) sendOutput(data []byte) {
+	if !c.queue.pushOutput(data) {
+		log.Warn("[Client.sendOutput] Disconnecting slow client", "email", c.Email)
+		c.queue.close()
+		c.conn.Close()
+	}
+}
+
+// Flush the messages already queued and disconnect the client
+func (c *Client) close() {
+	c.queue.close()
+}
+
+// Participant of a session as seen by other participants
+type Participant struct {
+	Email    string    `json:"email"`
+	Role     Role      `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// Send a message to every client. Must hold s.mu
+func (s *Session) broadcastLocked(msg *Message) {
+	for client := range s.clients {
+		client.send(msg)
+	}
+}
+
+// Tell every client who is attached to the session. Must hold s.mu
+func (s *Session) broadcastPresenceLocked() {
+	s.broadcastLocked(&Message{Type: MessagePresence, Participants: s.participantsLocked()})
+}
+
+// Must hold s.mu
+func (s *Session) participantsLocked() []Participant {
+	participants := []Participant{}
+	for client := range s.clients {
+		participants = append(participants, Participant{
+			Email:    client.Email,
+			Role:     client.Role,
+			JoinedAt: client.JoinedAt,
+		})
+	}
+	sort.Slice(participants, func(i, j int) bool {
+		return participants[i].JoinedAt.Before(participants[j].JoinedAt)
+	})
+	return participants
+}
+
+// Participants attached to the session
+func (s *Session) Participants() []Participant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.participantsLocked()
+}
+
+// Disconnect every client of the given user
+func (s *Session) Revoke(email string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revoked := false
+	for client := range s.clients {
+		if client.Email == email && client.Role != RoleOwner {
+			client.send(&Message{Type: MessageError, Error: ErrAccessRevoked.Error()})
+			client.close()
+			delete(s.clients, client)
+			revoked = true
+		}
+	}
+	if revoked {
+		s.broadcastPresenceLocked()
+		s.startDetachTimerLocked()
+	}
+}
+
+// Change the role of every client of the given user
+func (s *Session) SetRole(email string, role Role) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := false
+	for client := range s.clients {
+		if client.Email == email && client.Role != RoleOwner {
+			client.Role = role
+			changed = true
+		}
+	}
+	if changed {
+		s.broadcastPresenceLocked()
+	}
+}
+
+// Disconnect the user from every session of the container
+func (m *SessionManager) Revoke(containerID string, email string) {
+	for _, session := range m.containerSessions(containerID) {
+		session.Revoke(email)
+	}
+}
+
+// Change the role of the user on every session of the container
+func (m *SessionManager) SetRole(containerID string, email string, role Role) {
+	for _, session := range m.containerSessions(containerID) {
+		session.SetRole(email, role)
+	}
+}
+
+func (m *SessionManager) containerSessions(containerID string) []*Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sessions := []*Session{}
+	for _, session := range m.sessions {
+		if session.ContainerID == containerID {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
@@ -0,0 +1,185 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+// Run the code with a tty attached to a web socket client, the same way consoles are attached. Input
+// and resize messages of the client are forwarded to the program until it exits, then an exit
+// message is sent. `stdin`, if set, is typed into the program once it starts. A program that
+// exceeds a limit is killed and the client gets an error message with the reason. The dependencies
+// are installed first, if the install fails its output is sent instead and the program isn't run.
+// The keepalive of the connection is left to the caller.
+func RunInteractive(ctx context.Context, language Language, project Project, stdin *string, limits ExecutionLimits, user string, conn *ConsoleConn, width uint, height uint) error {
+	if errImage := EnsureImage(ctx, language.image()); errImage != nil {
+		return errImage
+	}
+	run, install, cleanup, errInstall := installDependencies(ctx, language, project, limits)
+	if errInstall != nil {
+		return errInstall
+	}
+	defer cleanup()
+	if install != nil && install.failed() {
+		return writeFailedInstall(conn, install)
+	}
+	language = run
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Only the program is subject to the timeout, not the wait for its exit
+	runCtx := ctx
+	if limits.Timeout > 0 {
+		var cancelRun context.CancelFunc
+		runCtx, cancelRun = context.WithTimeout(ctx, limits.Timeout)
+		defer cancelRun()
+	}
+
+	cache, errCache := buildCache.mount(ctx, language, user)
+	if errCache != nil {
+		return errCache
+	}
+	wc := &WebContainer{
+		Command:       "/bin/sh -c " + language.command(),
+		Args:          programArgs(project),
+		Env:           containerEnv(language, project, nil),
+		Image:         ImageType(language.image()),
+		AttachIO:      true,
+		AutoRemove:    false,
+		NetworkEnable: false,
+		Limits:        &limits,
+	}
+	if cache != nil {
+		wc.Mounts = []mount.Mount{*cache}
+	}
+	_, errCreate := wc.Create(ctx)
+	defer wc.RemoveContainer(context.Background())
+	if errCreate != nil {
+		return errCreate
+	}
+	// The output isn't parsed, there's no need for a build marker
+	if errCopy := copyProject(ctx, wc, language, project, ""); errCopy != nil {
+		return errCopy
+	}
+	stream, errAttach := wc.AttachContainer(ctx, AttachConfig{Stdin: true})
+	if errAttach != nil {
+		return errAttach
+	}
+	defer stream.Close()
+	if errStart := wc.Start(ctx); errStart != nil {
+		return errStart
+	}
+	if width > 0 && height > 0 {
+		dockerClient.ContainerResize(ctx, *wc.Id, container.ResizeOptions{Width: width, Height: height})
+	}
+	if stdin != nil {
+		stream.Conn.Write([]byte(*stdin))
+	}
+
+	var killMu sync.Mutex
+	killedReason := ""
+	kill := func(reason string) {
+		killMu.Lock()
+		if killedReason == "" {
+			killedReason = reason
+		}
+		killMu.Unlock()
+		dockerClient.ContainerKill(context.Background(), *wc.Id, "KILL")
+	}
+	stopTimeout := context.AfterFunc(runCtx, func() {
+		if ctx.Err() == nil {
+			kill(KilledTimeout)
+		}
+	})
+	defer stopTimeout()
+
+	// The client going away stops the program, the container is removed on return
+	go func() {
+		defer cancel()
+		defer stream.Close()
+		for {
+			message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			switch message.Type {
+			case MessageInput:
+				stream.Conn.Write(message.Data)
+			case MessageResize:
+				dockerClient.ContainerResize(ctx, *wc.Id, container.ResizeOptions{Width: message.Width, Height: message.Height})
+			case MessagePing:
+				conn.WriteMessage(&Message{Type: MessagePong})
+			default:
+				conn.WriteMessage(&Message{Type: MessageError, Error: ErrUnsupportedMessage.Error()})
+			}
+		}
+	}()
+
+	bufPtr := outputBufferPool.Get().(*[]byte)
+	defer outputBufferPool.Put(bufPtr)
+	output := *bufPtr
+	pending := 0
+	written := 0
+	for {
+		n, err := stream.Reader.Read(output[pending:])
+		if err != nil {
+			if pending > 0 {
+				conn.WriteOutput(output[:pending])
+			}
+			break
+		}
+		total := pending + n
+		cut := utf8Boundary(output[:total])
+		if limits.MaxOutputBytes > 0 && written+cut > limits.MaxOutputBytes {
+			kill(KilledOutput)
+			break
+		}
+		written += cut
+		if cut > 0 {
+			if errWrite := conn.WriteOutput(output[:cut]); errWrite != nil {
+				return nil
+			}
+		}
+		pending = copy(output, output[cut:total])
+	}
+
+	statusCh, errCh := dockerClient.ContainerWait(ctx, *wc.Id, container.WaitConditionNotRunning)
+	select {
+	case errWait := <-errCh:
+		if errors.Is(errWait, context.Canceled) {
+			return nil
+		}
+		return errWait
+	case status := <-statusCh:
+		log.Debug("[driver.RunInteractive] Program exited", "exit_code", status.StatusCode)
+		killMu.Lock()
+		reason := killedReason
+		killMu.Unlock()
+		if inspect, errInspect := dockerClient.ContainerInspect(ctx, *wc.Id); errInspect == nil && inspect.State != nil && inspect.State.OOMKilled && reason == "" {
+			reason = KilledMemory
+		}
+		if reason != "" {
+			conn.WriteMessage(&Message{Type: MessageError, Error: "program killed: " + reason, Reason: reason})
+		}
+		return conn.WriteMessage(&Message{Type: MessageExit, ExitCode: &status.StatusCode})
+	}
+}
+
+// Send the output of a failed install to the terminal, then its exit code
+func writeFailedInstall(conn *ConsoleConn, install *ExecutionResult) error {
+	// The output was produced without a tty, the terminal needs carriage returns
+	output := strings.ReplaceAll(install.Stdout+install.Stderr, "\n", "\r\n")
+	if errWrite := conn.WriteOutput([]byte(output)); errWrite != nil {
+		return nil
+	}
+	if install.KilledReason != "" {
+		conn.WriteMessage(&Message{Type: MessageError, Error: "dependency install killed: " + install.KilledReason, Reason: install.KilledReason})
+	}
+	return conn.WriteMessage(&Message{Type: MessageExit, ExitCode: &install.ExitCode})
+}
@@ -0,0 +1,223 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const (
+	MaxJudgeTests    = 100
+	DefaultTolerance = 1e-6
+)
+
+// Verdict of a test case, or of the whole submission
+type Verdict string
+
+const (
+	VerdictAccepted            Verdict = "accepted"
+	VerdictWrongAnswer         Verdict = "wrong_answer"
+	VerdictRuntimeError        Verdict = "runtime_error"
+	VerdictTimeLimitExceeded   Verdict = "time_limit_exceeded"
+	VerdictMemoryLimitExceeded Verdict = "memory_limit_exceeded"
+	VerdictOutputLimitExceeded Verdict = "output_limit_exceeded"
+	VerdictCompilationError    Verdict = "compilation_error"
+)
+
+// How the output of a test is compared with the expected output
+type Checker string
+
+const (
+	CheckerExact      Checker = "exact"      // Same output, line endings and trailing newlines aside
+	CheckerWhitespace Checker = "whitespace" // Same tokens, whitespace aside
+	CheckerFloat      Checker = "float"      // Same tokens, numbers within the tolerance
+)
+
+var ErrInvalidJudgeReq = errors.New("invalid judge request")
+
+type TestCase struct {
+	Name           string `json:"name"`
+	Stdin          string `json:"stdin"`
+	ExpectedStdout string `json:"expected_stdout"`
+	TimeLimitMs    int64  `json:"time_limit_ms"` // Optional, can't exceed the timeout of the user
+}
+
+// Submission of a project against test cases
+type JudgeReq struct {
+	CodeReq
+	Tests     []TestCase `json:"tests"`
+	Checker   Checker    `json:"checker"`   // Defaults to exact
+	Tolerance float64    `json:"tolerance"` // Absolute or relative error allowed by the float checker
+}
+
+type TestResult struct {
+	Name    string           `json:"name"`
+	Verdict Verdict          `json:"verdict"`
+	Result  *ExecutionResult `json:"result"`
+}
+
+type JudgeResult struct {
+	Verdict Verdict          `json:"verdict"` // First verdict that isn't accepted, if any
+	Install *ExecutionResult `json:"install,omitempty"`
+	Build   *ExecutionResult `json:"build,omitempty"`
+	Tests   []TestResult     `json:"tests"`
+}
+
+// Check the tests and set the defaults of the request
+func (judge *JudgeReq) Validate() error {
+	if len(judge.Tests) == 0 || len(judge.Tests) > MaxJudgeTests || judge.Tolerance < 0 {
+		return ErrInvalidJudgeReq
+	}
+	switch judge.Checker {
+	case "":
+		judge.Checker = CheckerExact
+	case CheckerExact, CheckerWhitespace, CheckerFloat:
+	default:
+		return ErrInvalidJudgeReq
+	}
+	if judge.Tolerance == 0 {
+		judge.Tolerance = DefaultTolerance
+	}
+	for _, test := range judge.Tests {
+		if test.TimeLimitMs < 0 {
+			return ErrInvalidJudgeReq
+		}
+	}
+	return nil
+}
+
+// Build the project once, then run it against each test case in a new container. Languages with a
+// build command are built in a container that is committed to an image, which the tests run from.
+// The dependencies are installed first, a failed install is a compilation error.
+func Judge(ctx context.Context, language Language, project Project, judge *JudgeReq, limits ExecutionLimits) (*JudgeResult, error) {
+	judgement := &JudgeResult{Verdict: VerdictAccepted, Tests: []TestResult{}}
+	run, install, cleanup, errInstall := installDependencies(ctx, language, project, limits)
+	if errInstall != nil {
+		return nil, errInstall
+	}
+	defer cleanup()
+	judgement.Install = install
+	if install != nil && install.failed() {
+		judgement.Verdict = VerdictCompilationError
+		return judgement, nil
+	}
+	if run.BuildCommand != "" {
+		build, built, errBuild := buildSubmission(ctx, run, project, limits)
+		if errBuild != nil {
+			return nil, errBuild
+		}
+		judgement.Build = build
+		if built == "" {
+			judgement.Verdict = VerdictCompilationError
+			return judgement, nil
+		}
+		defer removeImage(built)
+		run = run.committed(built)
+		run.BuildCommand = ""
+	}
+
+	for i, test := range judge.Tests {
+		testLimits := limits
+		if timeLimit := time.Duration(test.TimeLimitMs) * time.Millisecond; timeLimit > 0 && (limits.Timeout <= 0 || timeLimit < limits.Timeout) {
+			testLimits.Timeout = timeLimit
+		}
+		output := &outputCollector{}
+		result, errExec := HandleGenericExecution(ctx, run, project, ExecutionOptions{
+			Stdin:    strings.NewReader(test.Stdin),
+			Limits:   testLimits,
+			SkipPool: run != language,
+		}, output.handle)
+		if errExec != nil {
+			return nil, errExec
+		}
+		output.fill(result)
+
+		name := test.Name
+		if name == "" {
+			name = strconv.Itoa(i + 1)
+		}
+		verdict := testVerdict(result, judge, test.ExpectedStdout)
+		if judgement.Verdict == VerdictAccepted {
+			judgement.Verdict = verdict
+		}
+		judgement.Tests = append(judgement.Tests, TestResult{Name: name, Verdict: verdict, Result: result})
+	}
+	return judgement, nil
+}
+
+// Run the build command and commit the container, returns the reference of the image or an empty
+// string if the build failed
+func buildSubmission(ctx context.Context, language Language, project Project, limits ExecutionLimits) (*ExecutionResult, string, error) {
+	build := language
+	build.RunCommand = "true"
+	// The build outputs must be in the committed image, not in a build cache volume
+	build.BuildCache = ""
+	reference := ""
+	output := &outputCollector{}
+	result, errExec := HandleGenericExecution(ctx, build, project.withoutProgramEnv(), ExecutionOptions{
+		Limits:   limits,
+		SkipPool: true,
+		OnExit: func(containerID string, result *ExecutionResult) error {
+			if result.failed() {
+				return nil
+			}
+			var errCommit error
+			reference, errCommit = commitContainer(ctx, containerID)
+			return errCommit
+		},
+	}, output.handle)
+	if errExec != nil {
+		log.Error("[driver.buildSubmission] Error while building the submission", "error", errExec)
+		return nil, "", errExec
+	}
+	output.fill(result)
+	return result, reference, nil
+}
+
+func testVerdict(result *ExecutionResult, judge *JudgeReq, expected string) Verdict {
+	switch {
+	case result.TimedOut:
+		return VerdictTimeLimitExceeded
+	case result.OOMKilled || result.KilledReason == KilledMemory:
+		return VerdictMemoryLimitExceeded
+	case result.KilledReason == KilledOutput:
+		return VerdictOutputLimitExceeded
+	case result.ExitCode != 0:
+		return VerdictRuntimeError
+	case !checkOutput(judge.Checker, judge.Tolerance, result.Stdout, expected):
+		return VerdictWrongAnswer
+	}
+	return VerdictAccepted
+}
+
+func checkOutput(checker Checker, tolerance float64, output string, expected string) bool {
+	switch checker {
+	case CheckerWhitespace:
+		return slices.Equal(strings.Fields(output), strings.Fields(expected))
+	case CheckerFloat:
+		return slices.EqualFunc(strings.Fields(output), strings.Fields(expected), func(a, b string) bool {
+			return a == b || floatsEqual(a, b, tolerance)
+		})
+	}
+	normalize := func(text string) string {
+		return strings.TrimRight(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	}
+	return normalize(output) == normalize(expected)
+}
+
+// Both tokens are numbers within the absolute or relative tolerance
+func floatsEqual(a string, b string, tolerance float64) bool {
+	x, errX := strconv.ParseFloat(a, 64)
+	y, errY := strconv.ParseFloat(b, 64)
+	if errX != nil || errY != nil || math.IsNaN(x) || math.IsNaN(y) {
+		return false
+	}
+	diff := math.Abs(x - y)
+	return diff <= tolerance || diff <= tolerance*math.Abs(y)
+}
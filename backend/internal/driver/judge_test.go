@@ -0,0 +1,85 @@
+package driver
+
+import "testing"
+
+func TestCheckOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		checker  Checker
+		output   string
+		expected string
+		want     bool
+	}{
+		{"exact", CheckerExact, "1 2\n", "1 2\n", true},
+		{"exact trailing newlines", CheckerExact, "1 2\n\n", "1 2", true},
+		{"exact crlf", CheckerExact, "a\r\nb\r\n", "a\nb\n", true},
+		{"exact spaces matter", CheckerExact, "1  2\n", "1 2\n", false},
+		{"exact leading newline matters", CheckerExact, "\n1", "1", false},
+		{"exact different", CheckerExact, "1 2", "1 3", false},
+		{"whitespace", CheckerWhitespace, " 1\t2\n\n3 ", "1 2 3\n", true},
+		{"whitespace extra token", CheckerWhitespace, "1 2 3", "1 2", false},
+		{"whitespace empty", CheckerWhitespace, "\n", "", true},
+		{"float within tolerance", CheckerFloat, "0.3333333", "0.333333333", true},
+		{"float outside tolerance", CheckerFloat, "0.33", "0.333333333", false},
+		{"float mixed tokens", CheckerFloat, "YES 1.0000001", "YES 1", true},
+		{"float word differs", CheckerFloat, "NO 1", "YES 1", false},
+		{"float count differs", CheckerFloat, "1 2", "1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkOutput(tt.checker, DefaultTolerance, tt.output, tt.expected); got != tt.want {
+				t.Errorf("checkOutput(%q, %q) = %v, want %v", tt.output, tt.expected, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFloatsEqual(t *testing.T) {
+	tests := []struct {
+		a, b      string
+		tolerance float64
+		want      bool
+	}{
+		{"1", "1.0", 1e-6, true},
+		{"1.0000009", "1", 1e-6, true},
+		{"1.000002", "1", 1e-6, false},
+		// Relative error for big numbers
+		{"1000000001", "1000000000", 1e-6, true},
+		{"1000010000", "1000000000", 1e-6, false},
+		{"-0.5", "-0.5000001", 1e-6, true},
+		{"1e-7", "0", 1e-6, true},
+		{"NaN", "NaN", 1e-6, false},
+		{"abc", "1", 1e-6, false},
+		{"1", "", 1e-6, false},
+	}
+	for _, tt := range tests {
+		if got := floatsEqual(tt.a, tt.b, tt.tolerance); got != tt.want {
+			t.Errorf("floatsEqual(%q, %q, %g) = %v, want %v", tt.a, tt.b, tt.tolerance, got, tt.want)
+		}
+	}
+}
+
+func TestJudgeReqValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		judge JudgeReq
+		ok    bool
+	}{
+		{"defaults", JudgeReq{Tests: []TestCase{{}}}, true},
+		{"no tests", JudgeReq{}, false},
+		{"unknown checker", JudgeReq{Tests: []TestCase{{}}, Checker: "regex"}, false},
+		{"negative tolerance", JudgeReq{Tests: []TestCase{{}}, Tolerance: -1}, false},
+		{"negative time limit", JudgeReq{Tests: []TestCase{{TimeLimitMs: -1}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.judge.Validate()
+			if (err == nil) != tt.ok {
+				t.Fatalf("Validate() = %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && (tt.judge.Checker != CheckerExact || tt.judge.Tolerance != DefaultTolerance) {
+				t.Errorf("defaults = %q, %g", tt.judge.Checker, tt.judge.Tolerance)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package driver
+
+import (
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	DefaultMemoryMB       = 512
+	DefaultCPUs           = 1.0
+	DefaultPidsLimit      = 128
+	DefaultMaxOutputBytes = 1024 * 1024
+	DefaultExecTimeout    = 30 * time.Second
+)
+
+// Reasons an execution is killed by the server
+const (
+	KilledTimeout = "timeout"
+	KilledMemory  = "memory"
+	KilledOutput  = "output"
+)
+
+// Resource limits of a code execution, a zero value disables the limit
+type ExecutionLimits struct {
+	MemoryMB       int64         // Memory of the container, swap isn't allowed
+	CPUs           float64       // CPU quota, in number of CPUs
+	PidsLimit      int64         // Max number of processes and threads
+	MaxOutputBytes int           // Output of stdout and stderr combined, the program is killed past it
+	Timeout        time.Duration // Wall clock time from the start of the container, including the build
+}
+
+var executionDefaults = ExecutionLimits{
+	MemoryMB:       DefaultMemoryMB,
+	CPUs:           DefaultCPUs,
+	PidsLimit:      DefaultPidsLimit,
+	MaxOutputBytes: DefaultMaxOutputBytes,
+	Timeout:        DefaultExecTimeout,
+}
+
+// Init the default execution limits, must be call on server initialization
+func InitExecutionLimits(limits ExecutionLimits) {
+	executionDefaults = limits
+}
+
+// Limits used when none are configured for the user or language
+func ExecutionDefaults() ExecutionLimits {
+	return executionDefaults
+}
+
+// Docker resources enforcing the limits
+func (l ExecutionLimits) resources() container.Resources {
+	resources := container.Resources{}
+	if l.MemoryMB > 0 {
+		resources.Memory = l.MemoryMB * 1024 * 1024
+		resources.MemorySwap = resources.Memory
+	}
+	if l.CPUs > 0 {
+		resources.NanoCPUs = int64(l.CPUs * 1e9)
+	}
+	if l.PidsLimit > 0 {
+		resources.PidsLimit = &l.PidsLimit
+	}
+	return resources
+}
@@ -0,0 +1,229 @@
+package driver
+
+import (
+	"errors"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	outputReadSize          = 32 * 1024 // Size of the buffer the process output is read into
+	maxOutputFrame          = 64 * 1024 // Output queued for a client is coalesced up to this size
+	DefaultClientQueueBytes = 1024 * 1024
+)
+
+// What to do with a client that doesn't read its output fast enough
+type SlowConsumerPolicy string
+
+const (
+	// Disconnect the client, it can reattach and get the scrollback
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+	// Drop output until the client catches up, then tell it output was lost
+	SlowConsumerDrop SlowConsumerPolicy = "drop"
+)
+
+var (
+	ErrSlowConsumer  = errors.New("client too slow, disconnected")
+	ErrOutputDropped = errors.New("client too slow, output was dropped")
+)
+
+var outputBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, outputReadSize)
+		return &buf
+	},
+}
+
+var frameBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, maxOutputFrame)
+		return &buf
+	},
+}
+
+// Either output of the process or a control message
+type queueItem struct {
+	output []byte
+	msg    *Message
+}
+
+// Bounded queue of messages waiting to be written to a client
+type outputQueue struct {
+	limit  int // Max bytes of output queued
+	policy SlowConsumerPolicy
+
+	mu      sync.Mutex
+	items   []queueItem
+	bytes   int
+	dropped bool // Output was dropped since the last write
+	closed  bool
+	signal  chan struct{}
+}
+
+func newOutputQueue(limit int, policy SlowConsumerPolicy) *outputQueue {
+	return &outputQueue{
+		limit:  limit,
+		policy: policy,
+		signal: make(chan struct{}, 1),
+	}
+}
+
+// Queue output, returns false if the consumer is too slow and must be disconnected
+func (q *outputQueue) pushOutput(data []byte) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return true
+	}
+	if q.bytes+len(data) > q.limit {
+		if q.policy == SlowConsumerDrop {
+			q.dropped = true
+			return true
+		}
+		return false
+	}
+	if q.dropped {
+		q.items = append(q.items, queueItem{msg: &Message{Type: MessageError, Error: ErrOutputDropped.Error()}})
+		q.dropped = false
+	}
+	q.items = append(q.items, queueItem{output: data})
+	q.bytes += len(data)
+	q.notify()
+	return true
+}
+
+// Queue a control message, they aren't subject to the size limit
+func (q *outputQueue) pushMessage(msg *Message) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.items = append(q.items, queueItem{msg: msg})
+	q.notify()
+}
+
+// No more items can be queued, the ones already queued are still delivered
+func (q *outputQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.notify()
+}
+
+// Must hold q.mu
+func (q *outputQueue) notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Block until there are items queued and take all of them. `open` is false once the queue was
+// closed and every item delivered.
+func (q *outputQueue) take() (items []queueItem, open bool) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 || q.closed {
+			items, q.items = q.items, nil
+			q.bytes = 0
+			open = !q.closed || len(items) > 0
+			q.mu.Unlock()
+			return items, open
+		}
+		q.mu.Unlock()
+		<-q.signal
+	}
+}
+
+// Write everything queued to the connection until the queue is closed or a write fails. Consecutive
+// output is coalesced into frames of up to maxOutputFrame bytes.
+func (q *outputQueue) writeTo(conn *ConsoleConn) {
+	framePtr := frameBufferPool.Get().(*[]byte)
+	defer frameBufferPool.Put(framePtr)
+
+	flush := func() error {
+		frame := *framePtr
+		if len(frame) == 0 {
+			return nil
+		}
+		*framePtr = frame[:0]
+		return conn.WriteOutput(frame)
+	}
+
+	for {
+		items, open := q.take()
+		if !open {
+			return
+		}
+		for _, item := range items {
+			var err error
+			if item.msg != nil {
+				if err = flush(); err == nil {
+					err = conn.WriteMessage(item.msg)
+				}
+			} else {
+				for _, chunk := range splitUTF8(item.output, maxOutputFrame) {
+					if len(*framePtr)+len(chunk) > maxOutputFrame {
+						if err = flush(); err != nil {
+							break
+						}
+					}
+					*framePtr = append(*framePtr, chunk...)
+				}
+			}
+			if err != nil {
+				q.close()
+				conn.Close()
+				return
+			}
+		}
+		if err := flush(); err != nil {
+			q.close()
+			conn.Close()
+			return
+		}
+	}
+}
+
+// Length of the longest prefix of p that doesn't end in the middle of a UTF-8 sequence
+func utf8Boundary(p []byte) int {
+	// A UTF-8 sequence is at most utf8.UTFMax bytes long, only the tail needs checking
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(p[i]) {
+			continue
+		}
+		if utf8.FullRune(p[i:]) {
+			return len(p)
+		}
+		return i
+	}
+	return len(p)
+}
+
+// Skip the continuation bytes at the start of p, left over from a truncated UTF-8 sequence
+func trimUTF8Start(p []byte) []byte {
+	for i := 0; i < len(p) && i < utf8.UTFMax; i++ {
+		if utf8.RuneStart(p[i]) {
+			return p[i:]
+		}
+	}
+	return p
+}
+
+// Split p into chunks of at most max bytes without splitting UTF-8 sequences
+func splitUTF8(p []byte, max int) [][]byte {
+	chunks := [][]byte{}
+	for len(p) > max {
+		cut := utf8Boundary(p[:max])
+		if cut == 0 {
+			cut = max
+		}
+		chunks = append(chunks, p[:cut])
+		p = p[cut:]
+	}
+	if len(p) > 0 {
+		chunks = append(chunks, p)
+	}
+	return chunks
+}
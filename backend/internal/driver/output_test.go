@@ -0,0 +1,58 @@
+package driver
+
+import "testing"
+
+func TestUTF8Boundary(t *testing.T) {
+	euro := []byte("€") // 3 bytes
+	tests := []struct {
+		name string
+		p    []byte
+		want int
+	}{
+		{"empty", nil, 0},
+		{"ascii", []byte("abc"), 3},
+		{"complete sequence", append([]byte("a"), euro...), 4},
+		{"first byte of sequence", append([]byte("ab"), euro[0]), 2},
+		{"two bytes of sequence", append([]byte("ab"), euro[:2]...), 2},
+		{"only a partial sequence", euro[:2], 0},
+		{"emoji cut", []byte("x😀")[:4], 1},
+		{"invalid continuation bytes", []byte{'a', 0x80, 0x80}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := utf8Boundary(tt.p); got != tt.want {
+				t.Errorf("utf8Boundary(%q) = %d, want %d", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitUTF8(t *testing.T) {
+	tests := []struct {
+		name string
+		p    string
+		max  int
+		want []string
+	}{
+		{"empty", "", 4, []string{}},
+		{"fits", "abc", 4, []string{"abc"}},
+		{"exact", "abcd", 4, []string{"abcd"}},
+		{"ascii", "abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"sequence not split", "ab€cd", 4, []string{"ab", "€c", "d"}},
+		{"sequences", "€€€", 3, []string{"€", "€", "€"}},
+		{"max smaller than a sequence", "€", 2, []string{"\xe2\x82", "\xac"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitUTF8([]byte(tt.p), tt.max)
+			if len(got) != len(tt.want) {
+				t.Fatalf("splitUTF8(%q, %d) = %q, want %q", tt.p, tt.max, got, tt.want)
+			}
+			for i := range got {
+				if string(got[i]) != tt.want[i] {
+					t.Fatalf("splitUTF8(%q, %d) = %q, want %q", tt.p, tt.max, got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const (
+	DefaultPingInterval  = 30 * time.Second
+	DefaultWarningBefore = time.Minute
+	policyCheckInterval  = time.Second
+)
+
+// Reasons a session is ended by the server
+const (
+	ReasonIdle        = "idle"
+	ReasonMaxDuration = "max_duration"
+)
+
+// Limits of a console session, a zero duration disables the limit
+type SessionPolicy struct {
+	IdleTimeout time.Duration // Max time without input from any client nor output of the process
+	MaxDuration time.Duration // Max lifetime of the session
+}
+
+// Enforce the policy of the session, clients are warned `WarningBefore` the session is stopped
+func (s *Session) watchPolicy() {
+	if s.policy.IdleTimeout <= 0 && s.policy.MaxDuration <= 0 {
+		return
+	}
+	ticker := time.NewTicker(policyCheckInterval)
+	defer ticker.Stop()
+
+	var warnedIdle, warnedMax time.Time // Deadline the clients were warned about
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			if s.policy.MaxDuration > 0 {
+				deadline := s.CreatedAt.Add(s.policy.MaxDuration)
+				if s.enforceDeadline(now, deadline, ReasonMaxDuration, &warnedMax) {
+					return
+				}
+			}
+			if s.policy.IdleTimeout > 0 {
+				deadline := s.lastActivity().Add(s.policy.IdleTimeout)
+				if s.enforceDeadline(now, deadline, ReasonIdle, &warnedIdle) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Warn the clients if the deadline is close and stop the session once it's reached. Returns true if
+// the session was stopped.
+func (s *Session) enforceDeadline(now time.Time, deadline time.Time, reason string, warned *time.Time) bool {
+	remaining := deadline.Sub(now)
+	if remaining <= 0 {
+		log.Info("[Session.enforceDeadline] Stopping session", "id", s.ID, "reason", reason)
+		s.mu.Lock()
+		s.broadcastLocked(&Message{Type: MessageError, Error: "session stopped: " + reason, Reason: reason})
+		s.mu.Unlock()
+		if err := s.Stop(context.Background()); err != nil {
+			log.Error("[Session.enforceDeadline] Error while stopping the session", "id", s.ID, "error", err)
+		}
+		return true
+	}
+	if remaining <= s.manager.config.WarningBefore && !warned.Equal(deadline) {
+		*warned = deadline
+		s.mu.Lock()
+		s.broadcastLocked(&Message{Type: MessageWarning, Reason: reason, ExpiresIn: int64(remaining.Round(time.Second).Seconds())})
+		s.mu.Unlock()
+	}
+	return false
+}
+
+// Input and output both count as activity, a long running program printing its progress isn't idle
+func (s *Session) touch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touchLocked()
+}
+
+// Must hold s.mu
+func (s *Session) touchLocked() {
+	s.activeAt = time.Now()
+}
+
+func (s *Session) lastActivity() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.activeAt
+}
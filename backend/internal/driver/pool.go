@@ -0,0 +1,291 @@
+package driver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+const (
+	DefaultPoolMaxContainers  = 32
+	DefaultPoolMaxAge         = 30 * time.Minute
+	DefaultPoolHealthInterval = time.Minute
+	// Label of the pooled containers, used to remove the ones left by a previous run of the server
+	poolLabel         = "webconsole.pool"
+	poolCreateTimeout = 5 * time.Minute
+)
+
+// Configuration of the pool of code containers
+type PoolConfig struct {
+	Size           int           // Idle containers kept per language, 0 disables the pool
+	MaxContainers  int           // Idle containers kept for all the languages, 0 for no limit
+	MaxAge         time.Duration // Idle containers older than this are replaced
+	HealthInterval time.Duration // How often the idle containers are checked
+}
+
+// Metrics of the pool, since the server started
+type PoolStats struct {
+	Hits      uint64         `json:"hits"`
+	Misses    uint64         `json:"misses"`
+	HitRate   float64        `json:"hit_rate"`
+	Created   uint64         `json:"created"`
+	Discarded uint64         `json:"discarded"` // Unhealthy, expired or outdated containers
+	Failures  uint64         `json:"failures"`  // Containers that couldn't be created
+	Idle      map[string]int `json:"idle"`      // Idle containers per language
+}
+
+// Pool of created but never started containers, each one is claimed by a single code execution and
+// replaced in the background. Only executions without a tty use the pool.
+type containerPool struct {
+	config    PoolConfig
+	mu        sync.Mutex
+	languages map[string]*languagePool
+	stats     PoolStats
+}
+
+type languagePool struct {
+	language Language // Configuration the idle containers were created with
+	idle     []pooledContainer
+	creating int
+}
+
+type pooledContainer struct {
+	wc      *WebContainer
+	created time.Time
+}
+
+// nil when the pool is disabled
+var codePool *containerPool
+
+// Init the pool of code containers and remove the containers left by a previous run, must be call on
+// server initialization
+func InitPool(config PoolConfig) {
+	if config.Size <= 0 {
+		return
+	}
+	if config.MaxAge <= 0 {
+		config.MaxAge = DefaultPoolMaxAge
+	}
+	if config.HealthInterval <= 0 {
+		config.HealthInterval = DefaultPoolHealthInterval
+	}
+	removeStaleContainers()
+	codePool = &containerPool{config: config, languages: map[string]*languagePool{}}
+	go codePool.healthLoop()
+}
+
+// Fill the pool for the languages, the other languages are added on their first execution. Languages
+// with a build cache are skipped, their executions mount the cache of the user so they never claim a
+// pooled container.
+func WarmPool(languages []Language) {
+	if codePool == nil {
+		return
+	}
+	codePool.mu.Lock()
+	defer codePool.mu.Unlock()
+	for _, language := range languages {
+		if buildCache.enabled(language) {
+			continue
+		}
+		codePool.languagePool(language)
+		codePool.fill(language.Name)
+	}
+}
+
+// Metrics of the pool, zero values if the pool is disabled
+func GetPoolStats() PoolStats {
+	if codePool == nil {
+		return PoolStats{Idle: map[string]int{}}
+	}
+	codePool.mu.Lock()
+	defer codePool.mu.Unlock()
+	stats := codePool.stats
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	stats.Idle = make(map[string]int, len(codePool.languages))
+	for name, pool := range codePool.languages {
+		stats.Idle[name] = len(pool.idle)
+	}
+	return stats
+}
+
+// Take an idle container of the language, nil on a miss. The resources of the container are updated
+// to `limits`.
+func (p *containerPool) claim(ctx context.Context, language Language, limits ExecutionLimits) *WebContainer {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	pool := p.languagePool(language)
+	var claimed *pooledContainer
+	for len(pool.idle) > 0 && claimed == nil {
+		candidate := pool.idle[0]
+		pool.idle = pool.idle[1:]
+		if time.Since(candidate.created) > p.config.MaxAge {
+			p.stats.Discarded++
+			go candidate.wc.RemoveContainer(context.Background())
+			continue
+		}
+		claimed = &candidate
+	}
+	if claimed == nil {
+		p.stats.Misses++
+	} else {
+		p.stats.Hits++
+	}
+	p.fill(language.Name)
+	p.mu.Unlock()
+
+	if claimed == nil {
+		return nil
+	}
+	created := claimed.wc.Limits
+	if created.MemoryMB != limits.MemoryMB || created.CPUs != limits.CPUs || created.PidsLimit != limits.PidsLimit {
+		_, errUpdate := dockerClient.ContainerUpdate(ctx, *claimed.wc.Id, container.UpdateConfig{Resources: limits.resources()})
+		if errUpdate != nil {
+			log.Warn("[driver.claim] Error while updating the limits of a pooled container", "error", errUpdate)
+			go claimed.wc.RemoveContainer(context.Background())
+			return nil
+		}
+	}
+	claimed.wc.Limits = &limits
+	return claimed.wc
+}
+
+// Pool of the language, the idle containers are discarded if the language changed since they were
+// created. Must be called with the lock held.
+func (p *containerPool) languagePool(language Language) *languagePool {
+	pool, ok := p.languages[language.Name]
+	if !ok {
+		pool = &languagePool{language: language}
+		p.languages[language.Name] = pool
+	}
+	if pool.language != language {
+		for _, pooled := range pool.idle {
+			go pooled.wc.RemoveContainer(context.Background())
+		}
+		p.stats.Discarded += uint64(len(pool.idle))
+		pool.idle = nil
+		pool.language = language
+	}
+	return pool
+}
+
+// Create containers in the background until the pool of the language is full. Must be called with
+// the lock held.
+func (p *containerPool) fill(name string) {
+	pool := p.languages[name]
+	total := 0
+	for _, other := range p.languages {
+		total += len(other.idle) + other.creating
+	}
+	for len(pool.idle)+pool.creating < p.config.Size && (p.config.MaxContainers <= 0 || total < p.config.MaxContainers) {
+		pool.creating++
+		total++
+		go p.create(pool.language)
+	}
+}
+
+func (p *containerPool) create(language Language) {
+	ctx, cancel := context.WithTimeout(context.Background(), poolCreateTimeout)
+	defer cancel()
+	wc := newCodeContainer(language, Project{Entrypoint: language.Filename}, ExecutionOptions{Limits: ExecutionDefaults()})
+	wc.Labels = map[string]string{poolLabel: language.Name}
+	errCreate := EnsureImage(ctx, language.image())
+	if errCreate == nil {
+		_, errCreate = wc.Create(ctx)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pool := p.languages[language.Name]
+	pool.creating--
+	if errCreate != nil {
+		// Retried on the next claim or health check
+		p.stats.Failures++
+		log.Error("[driver.create] Error while creating a pooled container", "language", language.Name, "error", errCreate)
+		return
+	}
+	p.stats.Created++
+	if pool.language != language {
+		p.stats.Discarded++
+		go wc.RemoveContainer(context.Background())
+		return
+	}
+	pool.idle = append(pool.idle, pooledContainer{wc: wc, created: time.Now()})
+}
+
+// Periodically remove the idle containers that are expired or no longer in the created state, then
+// fill the pools again
+func (p *containerPool) healthLoop() {
+	ticker := time.NewTicker(p.config.HealthInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.mu.Lock()
+		var checked []pooledContainer
+		for _, pool := range p.languages {
+			checked = append(checked, pool.idle...)
+		}
+		p.mu.Unlock()
+
+		unhealthy := map[*WebContainer]bool{}
+		for _, pooled := range checked {
+			if time.Since(pooled.created) > p.config.MaxAge || !pooled.healthy() {
+				unhealthy[pooled.wc] = true
+			}
+		}
+
+		p.mu.Lock()
+		for name, pool := range p.languages {
+			idle := pool.idle[:0]
+			for _, pooled := range pool.idle {
+				if unhealthy[pooled.wc] {
+					p.stats.Discarded++
+					go pooled.wc.RemoveContainer(context.Background())
+				} else {
+					idle = append(idle, pooled)
+				}
+			}
+			pool.idle = idle
+			p.fill(name)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// The container still exists and was never started
+func (c pooledContainer) healthy() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	inspect, errInspect := dockerClient.ContainerInspect(ctx, *c.wc.Id)
+	if errInspect != nil {
+		log.Warn("[driver.healthy] Error while inspecting a pooled container", "error", errInspect)
+		return false
+	}
+	return inspect.State != nil && inspect.State.Status == "created"
+}
+
+// Remove the pooled containers of a previous run of the server
+func removeStaleContainers() {
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	containers, errList := dockerClient.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", poolLabel)),
+	})
+	if errList != nil {
+		log.Error("[driver.removeStaleContainers] Error while listing the pooled containers", "error", errList)
+		return
+	}
+	for _, stale := range containers {
+		dockerClient.ContainerRemove(ctx, stale.ID, container.RemoveOptions{Force: true})
+	}
+	if len(containers) > 0 {
+		log.Info("[driver.removeStaleContainers] Removed pooled containers of a previous run", "count", len(containers))
+	}
+}
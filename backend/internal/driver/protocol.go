@@ -0,0 +1,224 @@
+package driver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Subprotocols understood by the console web socket. A client that doesn't request any of them
+// falls back to the legacy mode, where every frame sent to the server is raw input and every frame
+// sent to the client is base64 encoded output.
+//
+// On v1 every frame is a JSON message. v2 uses the same JSON messages for control frames, but
+// output is sent as raw binary frames and binary frames from the client are raw input.
+const (
+	ProtocolLegacy = ""
+	ProtocolV1     = "webconsole.v1"
+	ProtocolV2     = "webconsole.v2"
+)
+
+// Subprotocols offered by the server, in order of preference
+var SupportedProtocols = []string{ProtocolV2, ProtocolV1}
+
+// Max time to write a frame before considering the client dead
+const (
+	writeWait = 10 * time.Second
+	// Messages of the client buffered by ReadAhead while nobody reads them
+	readAheadMessages = 64
+)
+
+type MessageType string
+
+const (
+	MessageInput  MessageType = "input"  // client -> server, data written to the container
+	MessageOutput MessageType = "output" // server -> client, data read from the container
+	MessageResize MessageType = "resize" // client -> server, resize the tty
+	MessagePing   MessageType = "ping"   // client -> server, answered with a pong
+	MessagePong   MessageType = "pong"   // server -> client
+	MessageExit   MessageType = "exit"   // server -> client, the process exited
+	MessageError  MessageType = "error"  // server -> client, something went wrong on the server
+
+	MessagePresence MessageType = "presence" // server -> client, the participants of the session changed
+	MessageWarning  MessageType = "warning"  // server -> client, the session will be stopped soon
+)
+
+// A single frame of the console protocol. Binary payloads are base64 encoded by encoding/json.
+type Message struct {
+	Type     MessageType `json:"type"`
+	Data     []byte      `json:"data,omitempty"`
+	Width    uint        `json:"width,omitempty"`
+	Height   uint        `json:"height,omitempty"`
+	ExitCode *int64      `json:"exit_code,omitempty"`
+	Error    string      `json:"error,omitempty"`
+
+	Participants []Participant `json:"participants,omitempty"`
+
+	Reason    string `json:"reason,omitempty"`     // Why the session is stopped, see ReasonIdle
+	ExpiresIn int64  `json:"expires_in,omitempty"` // Seconds until the session is stopped
+}
+
+var ErrUnsupportedMessage = errors.New("unsupported message type")
+
+// A web socket connection speaking one of the console protocols. Writes are serialized since
+// gorilla/websocket only supports one concurrent writer.
+type ConsoleConn struct {
+	ws       *websocket.Conn
+	protocol string
+	writeMu  sync.Mutex
+	pongWait time.Duration // Read deadline when keepalive is enabled
+	// Messages read in the background, see ReadAhead. Once closed the reads fail with `readErr`.
+	messages chan *Message
+	readErr  error
+}
+
+// Wrap a web socket, the protocol is taken from the negotiated subprotocol
+func NewConsoleConn(ws *websocket.Conn) *ConsoleConn {
+	return &ConsoleConn{
+		ws:       ws,
+		protocol: ws.Subprotocol(),
+	}
+}
+
+func (c *ConsoleConn) Protocol() string {
+	return c.protocol
+}
+
+// Read the next message sent by the client
+func (c *ConsoleConn) ReadMessage() (*Message, error) {
+	if c.messages != nil {
+		msg, ok := <-c.messages
+		if !ok {
+			return nil, c.readErr
+		}
+		return msg, nil
+	}
+	return c.readMessage()
+}
+
+// Read the client in the background from now on, so a client going away is noticed before anyone
+// reads its messages: `gone` is then called. ReadMessage returns the messages in order, up to
+// `readAheadMessages` are buffered before the background reads wait. Must be called after Keepalive
+// and before any read.
+func (c *ConsoleConn) ReadAhead(gone func()) {
+	c.messages = make(chan *Message, readAheadMessages)
+	go func() {
+		for {
+			msg, err := c.readMessage()
+			if err != nil {
+				c.readErr = err
+				close(c.messages)
+				gone()
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+}
+
+func (c *ConsoleConn) readMessage() (*Message, error) {
+	frameType, data, err := c.ws.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	c.extendDeadline()
+	if c.protocol == ProtocolLegacy || (c.protocol == ProtocolV2 && frameType == websocket.BinaryMessage) {
+		return &Message{Type: MessageInput, Data: data}, nil
+	}
+
+	var msg Message
+	if errJSON := json.Unmarshal(data, &msg); errJSON != nil {
+		return nil, errJSON
+	}
+	return &msg, nil
+}
+
+// Send a message to the client. Messages that can't be represented on the legacy protocol are
+// silently dropped.
+func (c *ConsoleConn) WriteMessage(msg *Message) error {
+	if msg.Type == MessageOutput && c.protocol != ProtocolV1 {
+		return c.WriteOutput(msg.Data)
+	}
+	if c.protocol == ProtocolLegacy {
+		return nil
+	}
+	frame, errJSON := json.Marshal(msg)
+	if errJSON != nil {
+		return errJSON
+	}
+	return c.writeFrame(websocket.TextMessage, frame)
+}
+
+// Send output to the client in the encoding of the protocol
+func (c *ConsoleConn) WriteOutput(data []byte) error {
+	switch c.protocol {
+	case ProtocolLegacy:
+		return c.writeFrame(websocket.TextMessage, []byte(base64.StdEncoding.EncodeToString(data)))
+	case ProtocolV2:
+		return c.writeFrame(websocket.BinaryMessage, data)
+	default:
+		frame, errJSON := json.Marshal(&Message{Type: MessageOutput, Data: data})
+		if errJSON != nil {
+			return errJSON
+		}
+		return c.writeFrame(websocket.TextMessage, frame)
+	}
+}
+
+func (c *ConsoleConn) writeFrame(frameType int, frame []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.ws.WriteMessage(frameType, frame)
+}
+
+// Send an error frame to the client
+func (c *ConsoleConn) WriteError(err error) error {
+	return c.WriteMessage(&Message{Type: MessageError, Error: err.Error()})
+}
+
+// Ping the client every `interval` until `stop` is closed. A client that doesn't answer or send
+// anything for two intervals is considered dead and its reads fail. Must be called before reading.
+func (c *ConsoleConn) Keepalive(interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	c.pongWait = 2 * interval
+	c.extendDeadline()
+	c.ws.SetPongHandler(func(string) error {
+		c.extendDeadline()
+		return nil
+	})
+	go c.pingLoop(interval, stop)
+}
+
+func (c *ConsoleConn) pingLoop(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// WriteControl is safe to call concurrently with the other writes
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+func (c *ConsoleConn) extendDeadline() {
+	if c.pongWait > 0 {
+		c.ws.SetReadDeadline(time.Now().Add(c.pongWait))
+	}
+}
+
+func (c *ConsoleConn) Close() error {
+	return c.ws.Close()
+}
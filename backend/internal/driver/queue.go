@@ -0,0 +1,343 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultQueueWorkers     = 4
+	DefaultQueueUserWorkers = 1
+	DefaultQueueMaxDepth    = 100
+	DefaultQueueUserDepth   = 10
+	DefaultJobRetention     = time.Hour
+)
+
+var (
+	ErrQueueFull     = errors.New("execution queue is full")
+	ErrUserQueueFull = errors.New("too many queued executions for the user")
+	ErrJobNotFound   = errors.New("job not found")
+	ErrJobFinished   = errors.New("job already finished")
+)
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobDone      JobStatus = "done"
+	JobFailed    JobStatus = "failed" // The execution couldn't run, the program exiting with an error is done
+	JobCancelled JobStatus = "cancelled"
+)
+
+// Configuration of the execution queue
+type QueueConfig struct {
+	Workers     int           // Executions running at once, for every user
+	UserWorkers int           // Executions running at once for a single user
+	MaxDepth    int           // Queued executions, for every user
+	UserDepth   int           // Queued executions of a single user
+	Retention   time.Duration // Finished jobs are kept this long for their result
+}
+
+// Execution submitted to the queue
+type Job struct {
+	ID         string           `json:"id"`
+	Status     JobStatus        `json:"status"`
+	Position   int              `json:"position,omitempty"` // Among the queued jobs of the user, from 1
+	CreatedAt  time.Time        `json:"created_at"`
+	StartedAt  *time.Time       `json:"started_at,omitempty"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+	Result     *ExecutionResult `json:"result,omitempty"`
+	Error      string           `json:"error,omitempty"`
+
+	email  string
+	run    func(ctx context.Context) (*ExecutionResult, error)
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Metrics of the queue, counters are since the server started
+type QueueStats struct {
+	Queued      int            `json:"queued"`
+	Running     int            `json:"running"`
+	Workers     int            `json:"workers"`
+	QueuedUsers int            `json:"queued_users"` // Users with queued jobs
+	Submitted   uint64         `json:"submitted"`
+	Rejected    uint64         `json:"rejected"` // Submitted to a full queue
+	Finished    map[string]int `json:"finished"` // Finished jobs by status
+	AvgWaitMs   int64          `json:"avg_wait_ms"`
+	MaxWaitMs   int64          `json:"max_wait_ms"`
+}
+
+// Queue of executions, each user has its own FIFO and the users with queued jobs are served in turn
+type jobQueue struct {
+	config        QueueConfig
+	mu            sync.Mutex
+	jobs          map[string]*Job
+	pending       map[string][]*Job
+	users         []string // Users with queued jobs, in the order they are served
+	next          int
+	running       int
+	runningByUser map[string]int
+	stats         QueueStats
+	totalWait     time.Duration
+	started       uint64
+}
+
+var queue = newJobQueue(QueueConfig{
+	Workers:     DefaultQueueWorkers,
+	UserWorkers: DefaultQueueUserWorkers,
+	MaxDepth:    DefaultQueueMaxDepth,
+	UserDepth:   DefaultQueueUserDepth,
+	Retention:   DefaultJobRetention,
+})
+
+// Init the execution queue, must be call on server initialization
+func InitQueue(config QueueConfig) {
+	if config.Retention <= 0 {
+		config.Retention = DefaultJobRetention
+	}
+	queue = newJobQueue(config)
+}
+
+func newJobQueue(config QueueConfig) *jobQueue {
+	return &jobQueue{
+		config:        config,
+		jobs:          map[string]*Job{},
+		pending:       map[string][]*Job{},
+		runningByUser: map[string]int{},
+		stats:         QueueStats{Finished: map[string]int{}},
+	}
+}
+
+// Queue an execution of the user, `run` is called once a worker is free. The context passed to
+// `run` is cancelled when the job is.
+func SubmitJob(email string, run func(ctx context.Context) (*ExecutionResult, error)) (Job, error) {
+	id, errID := newSessionID()
+	if errID != nil {
+		return Job{}, errID
+	}
+	job := &Job{ID: id, Status: JobQueued, CreatedAt: time.Now(), email: email, run: run, done: make(chan struct{})}
+
+	q := queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queued := q.queued()
+	if q.config.MaxDepth > 0 && queued >= q.config.MaxDepth {
+		q.stats.Rejected++
+		return Job{}, ErrQueueFull
+	}
+	if q.config.UserDepth > 0 && len(q.pending[email]) >= q.config.UserDepth {
+		q.stats.Rejected++
+		return Job{}, ErrUserQueueFull
+	}
+	if len(q.pending[email]) == 0 {
+		q.users = append(q.users, email)
+	}
+	q.pending[email] = append(q.pending[email], job)
+	q.jobs[id] = job
+	q.stats.Submitted++
+	q.dispatch()
+	return q.snapshot(job), nil
+}
+
+// Get a job of the user
+func GetJob(id string, email string) (Job, error) {
+	q := queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok || job.email != email {
+		return Job{}, ErrJobNotFound
+	}
+	return q.snapshot(job), nil
+}
+
+// Wait for a job of the user to finish, the job is cancelled if `ctx` is
+func WaitJob(ctx context.Context, id string, email string) (Job, error) {
+	queue.mu.Lock()
+	job, ok := queue.jobs[id]
+	queue.mu.Unlock()
+	if !ok || job.email != email {
+		return Job{}, ErrJobNotFound
+	}
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+		CancelJob(id, email)
+		<-job.done
+	}
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+	return queue.snapshot(job), nil
+}
+
+// Cancel a queued or running job of the user
+func CancelJob(id string, email string) (Job, error) {
+	q := queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok || job.email != email {
+		return Job{}, ErrJobNotFound
+	}
+	switch job.Status {
+	case JobQueued:
+		pending := q.pending[email]
+		for i, queued := range pending {
+			if queued == job {
+				q.pending[email] = append(pending[:i:i], pending[i+1:]...)
+				break
+			}
+		}
+		q.removeIdleUsers()
+		q.finish(job, nil, context.Canceled)
+	case JobRunning:
+		// The worker finishes the job once the execution stops
+		job.cancel()
+	default:
+		return q.snapshot(job), ErrJobFinished
+	}
+	return q.snapshot(job), nil
+}
+
+// Metrics of the queue
+func GetQueueStats() QueueStats {
+	q := queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Finished = make(map[string]int, len(q.stats.Finished))
+	for status, count := range q.stats.Finished {
+		stats.Finished[status] = count
+	}
+	stats.Queued = q.queued()
+	stats.Running = q.running
+	stats.Workers = q.config.Workers
+	stats.QueuedUsers = len(q.users)
+	if q.started > 0 {
+		stats.AvgWaitMs = (q.totalWait / time.Duration(q.started)).Milliseconds()
+	}
+	return stats
+}
+
+// Must be called with the lock held
+func (q *jobQueue) queued() int {
+	queued := 0
+	for _, pending := range q.pending {
+		queued += len(pending)
+	}
+	return queued
+}
+
+// Copy of the job with its position, must be called with the lock held
+func (q *jobQueue) snapshot(job *Job) Job {
+	copied := *job
+	if job.Status == JobQueued {
+		for i, queued := range q.pending[job.email] {
+			if queued == job {
+				copied.Position = i + 1
+			}
+		}
+	}
+	return copied
+}
+
+// Start the queued jobs while there are free workers. The users are served in turn, skipping the
+// ones already running as many jobs as they can. Must be called with the lock held.
+func (q *jobQueue) dispatch() {
+	for (q.config.Workers <= 0 || q.running < q.config.Workers) && len(q.users) > 0 {
+		started := false
+		for i := 0; i < len(q.users) && !started; i++ {
+			index := (q.next + i) % len(q.users)
+			email := q.users[index]
+			if q.config.UserWorkers > 0 && q.runningByUser[email] >= q.config.UserWorkers {
+				continue
+			}
+			job := q.pending[email][0]
+			q.pending[email] = q.pending[email][1:]
+			q.next = index + 1
+			q.start(job)
+			started = true
+		}
+		q.removeIdleUsers()
+		if !started {
+			return
+		}
+	}
+}
+
+// Must be called with the lock held
+func (q *jobQueue) removeIdleUsers() {
+	users := q.users[:0]
+	for i, email := range q.users {
+		if len(q.pending[email]) > 0 {
+			users = append(users, email)
+			continue
+		}
+		delete(q.pending, email)
+		if i < q.next {
+			q.next--
+		}
+	}
+	q.users = users
+	if len(q.users) == 0 || q.next >= len(q.users) {
+		q.next = 0
+	}
+}
+
+// Must be called with the lock held
+func (q *jobQueue) start(job *Job) {
+	now := time.Now()
+	job.Status = JobRunning
+	job.StartedAt = &now
+	wait := now.Sub(job.CreatedAt)
+	q.totalWait += wait
+	q.started++
+	q.stats.MaxWaitMs = max(q.stats.MaxWaitMs, wait.Milliseconds())
+	q.running++
+	q.runningByUser[job.email]++
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job.cancel = cancel
+	go func() {
+		defer cancel()
+		result, errRun := job.run(ctx)
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.running--
+		if q.runningByUser[job.email]--; q.runningByUser[job.email] == 0 {
+			delete(q.runningByUser, job.email)
+		}
+		if ctx.Err() != nil {
+			errRun = context.Canceled
+		}
+		q.finish(job, result, errRun)
+		q.dispatch()
+	}()
+}
+
+// Set the final state of the job and forget it after the retention, must be called with the lock held
+func (q *jobQueue) finish(job *Job, result *ExecutionResult, err error) {
+	now := time.Now()
+	job.FinishedAt = &now
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.Status = JobCancelled
+	case err != nil:
+		job.Status = JobFailed
+		job.Error = err.Error()
+	default:
+		job.Status = JobDone
+		job.Result = result
+	}
+	q.stats.Finished[string(job.Status)]++
+	close(job.done)
+	time.AfterFunc(q.config.Retention, func() {
+		q.mu.Lock()
+		delete(q.jobs, job.ID)
+		q.mu.Unlock()
+	})
+}
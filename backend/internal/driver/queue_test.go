@@ -0,0 +1,163 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Replace the queue for a test, restored once the test ends
+func withQueue(t *testing.T, config QueueConfig) {
+	previous := queue
+	queue = newJobQueue(config)
+	t.Cleanup(func() { queue = previous })
+}
+
+// Job that reports its label once started and runs until released
+func blockingJob(label string, started chan<- string, release <-chan struct{}) func(ctx context.Context) (*ExecutionResult, error) {
+	return func(ctx context.Context) (*ExecutionResult, error) {
+		started <- label
+		select {
+		case <-release:
+			return &ExecutionResult{}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func TestQueueFairDispatch(t *testing.T) {
+	type submission struct{ email, label string }
+	tests := []struct {
+		name        string
+		userWorkers int
+		submissions []submission
+		want        []string
+	}{
+		{"single user is FIFO", 0,
+			[]submission{{"a", "a1"}, {"a", "a2"}, {"a", "a3"}},
+			[]string{"a1", "a2", "a3"}},
+		{"users served in turn", 0,
+			[]submission{{"a", "a1"}, {"a", "a2"}, {"a", "a3"}, {"b", "b1"}, {"b", "b2"}, {"c", "c1"}},
+			[]string{"a1", "a2", "b1", "c1", "a3", "b2"}},
+		{"late user joins the rotation", 1,
+			[]submission{{"a", "a1"}, {"b", "b1"}, {"a", "a2"}, {"a", "a3"}, {"c", "c1"}},
+			[]string{"a1", "b1", "a2", "c1", "a3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A single worker makes the order of the starts deterministic
+			withQueue(t, QueueConfig{Workers: 1, UserWorkers: tt.userWorkers, Retention: time.Minute})
+			started := make(chan string, len(tt.submissions))
+			release := make(chan struct{})
+			for _, s := range tt.submissions {
+				if _, err := SubmitJob(s.email, blockingJob(s.label, started, release)); err != nil {
+					t.Fatalf("SubmitJob(%q) = %v", s.label, err)
+				}
+			}
+			order := []string{}
+			for range tt.submissions {
+				select {
+				case label := <-started:
+					order = append(order, label)
+				case <-time.After(time.Second):
+					t.Fatalf("started %v, then no job started", order)
+				}
+				release <- struct{}{}
+			}
+			if !reflect.DeepEqual(order, tt.want) {
+				t.Errorf("start order = %v, want %v", order, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueUserWorkers(t *testing.T) {
+	withQueue(t, QueueConfig{Workers: 2, UserWorkers: 1, Retention: time.Minute})
+	started := make(chan string, 3)
+	release := make(chan struct{})
+	defer close(release)
+	a1, _ := SubmitJob("a", blockingJob("a1", started, release))
+	a2, _ := SubmitJob("a", blockingJob("a2", started, release))
+	b1, _ := SubmitJob("b", blockingJob("b1", started, release))
+
+	tests := []struct {
+		job    Job
+		email  string
+		status JobStatus
+	}{
+		{a1, "a", JobRunning},
+		{a2, "a", JobQueued},
+		{b1, "b", JobRunning},
+	}
+	for _, tt := range tests {
+		job, err := GetJob(tt.job.ID, tt.email)
+		if err != nil || job.Status != tt.status {
+			t.Errorf("GetJob(%s) = %v, %v, want %v", tt.job.ID, job.Status, err, tt.status)
+		}
+	}
+	if job, _ := GetJob(a2.ID, "a"); job.Position != 1 {
+		t.Errorf("position = %d, want 1", job.Position)
+	}
+	if _, err := GetJob(a1.ID, "b"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("GetJob() of another user = %v, want %v", err, ErrJobNotFound)
+	}
+}
+
+func TestQueueDepth(t *testing.T) {
+	tests := []struct {
+		name   string
+		config QueueConfig
+		emails []string
+		want   error // Of the last submission
+	}{
+		{"within limits", QueueConfig{Workers: 1, MaxDepth: 2, UserDepth: 2}, []string{"a", "a", "a"}, nil},
+		{"user depth", QueueConfig{Workers: 1, MaxDepth: 10, UserDepth: 1}, []string{"a", "a", "a"}, ErrUserQueueFull},
+		{"other user not limited", QueueConfig{Workers: 1, MaxDepth: 10, UserDepth: 1}, []string{"a", "a", "b"}, nil},
+		{"max depth", QueueConfig{Workers: 1, MaxDepth: 1, UserDepth: 10}, []string{"a", "a", "b"}, ErrQueueFull},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Retention = time.Minute
+			withQueue(t, tt.config)
+			started := make(chan string, len(tt.emails))
+			release := make(chan struct{})
+			defer close(release)
+			var err error
+			for _, email := range tt.emails {
+				_, err = SubmitJob(email, blockingJob(email, started, release))
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("SubmitJob() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	withQueue(t, QueueConfig{Workers: 1, Retention: time.Minute})
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	running, _ := SubmitJob("a", blockingJob("running", started, release))
+	queued, _ := SubmitJob("a", blockingJob("queued", started, release))
+	<-started
+
+	if job, err := CancelJob(queued.ID, "a"); err != nil || job.Status != JobCancelled {
+		t.Errorf("CancelJob(queued) = %v, %v, want %v", job.Status, err, JobCancelled)
+	}
+	if _, err := CancelJob(running.ID, "a"); err != nil {
+		t.Errorf("CancelJob(running) = %v", err)
+	}
+	job, err := WaitJob(context.Background(), running.ID, "a")
+	if err != nil || job.Status != JobCancelled {
+		t.Errorf("WaitJob(running) = %v, %v, want %v", job.Status, err, JobCancelled)
+	}
+	if _, err := CancelJob(running.ID, "a"); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("CancelJob(finished) = %v, want %v", err, ErrJobFinished)
+	}
+	if stats := GetQueueStats(); stats.Queued != 0 || stats.Running != 0 || stats.Finished[string(JobCancelled)] != 2 {
+		t.Errorf("GetQueueStats() = %+v", stats)
+	}
+}
@@ -0,0 +1,312 @@
+package driver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+const (
+	DefaultRecordingsDir = "recordings"
+	recordingExt         = ".cast"
+	// Pauses longer than this are shortened on replay
+	DefaultReplayIdleLimit = 2 * time.Second
+)
+
+var (
+	ErrRecordingNotFound = errors.New("recording not found")
+	ErrInvalidRecording  = errors.New("invalid recording")
+
+	recordingIDRegex = regexp.MustCompile(`^[a-f0-9]+-[0-9]+$`)
+)
+
+// Directory where the recordings are stored, one subdirectory per container
+var RecordingsDir = DefaultRecordingsDir
+
+// Init the recordings directory, must be call on server initialization
+func InitRecordings(dir string) {
+	RecordingsDir = dir
+}
+
+// Header of an asciicast v2 file, https://docs.asciinema.org/manual/asciicast/v2/
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     uint              `json:"width"`
+	Height    uint              `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recording metadata returned by the API
+type RecordingInfo struct {
+	ID          string    `json:"id"`
+	ContainerID string    `json:"containerid"`
+	Title       string    `json:"title"`
+	StartedAt   time.Time `json:"started_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Size        int64     `json:"size"`
+}
+
+// Writes the events of a console session to an asciicast v2 file
+type Recorder struct {
+	mu     sync.Mutex
+	file   *os.File
+	start  time.Time
+	closed bool
+}
+
+// Create the recording file of a session
+func NewRecorder(containerID string, sessionID string, title string, width uint, height uint) (*Recorder, error) {
+	dir := filepath.Join(RecordingsDir, containerID)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	name := sessionID + "-" + strconv.FormatInt(start.Unix(), 10) + recordingExt
+	file, errFile := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if errFile != nil {
+		return nil, errFile
+	}
+
+	header, errJSON := json.Marshal(castHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: start.Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": "xterm-256color"},
+	})
+	if errJSON != nil {
+		file.Close()
+		return nil, errJSON
+	}
+	if _, errWrite := file.Write(append(header, '\n')); errWrite != nil {
+		file.Close()
+		return nil, errWrite
+	}
+
+	return &Recorder{file: file, start: start}, nil
+}
+
+// Record output of the process, `data` must not end in the middle of a UTF-8 sequence
+func (r *Recorder) Output(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writeEventLocked("o", string(data))
+}
+
+// Record input sent by a client
+func (r *Recorder) Input(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writeEventLocked("i", string(data))
+}
+
+// Record a resize of the terminal
+func (r *Recorder) Resize(width uint, height uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writeEventLocked("r", strconv.FormatUint(uint64(width), 10)+"x"+strconv.FormatUint(uint64(height), 10))
+}
+
+func (r *Recorder) writeEventLocked(code string, data string) {
+	if r.closed {
+		return
+	}
+	elapsed := time.Since(r.start).Seconds()
+	event, err := json.Marshal([]any{elapsed, code, data})
+	if err != nil {
+		return
+	}
+	r.file.Write(append(event, '\n'))
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.file.Close()
+}
+
+func recordingPath(containerID string, recordingID string) (string, error) {
+	if !recordingIDRegex.MatchString(recordingID) || strings.ContainsAny(containerID, `/\.`) {
+		return "", ErrRecordingNotFound
+	}
+	path := filepath.Join(RecordingsDir, containerID, recordingID+recordingExt)
+	if _, err := os.Stat(path); err != nil {
+		return "", ErrRecordingNotFound
+	}
+	return path, nil
+}
+
+// List the recordings of a container, newest first
+func ListRecordings(containerID string) ([]RecordingInfo, error) {
+	if strings.ContainsAny(containerID, `/\.`) {
+		return nil, ErrRecordingNotFound
+	}
+	entries, err := os.ReadDir(filepath.Join(RecordingsDir, containerID))
+	if errors.Is(err, os.ErrNotExist) {
+		return []RecordingInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	recordings := []RecordingInfo{}
+	for _, entry := range entries {
+		id, isCast := strings.CutSuffix(entry.Name(), recordingExt)
+		if !isCast || !recordingIDRegex.MatchString(id) {
+			continue
+		}
+		info, errInfo := entry.Info()
+		if errInfo != nil {
+			continue
+		}
+		path := filepath.Join(RecordingsDir, containerID, entry.Name())
+		header, errHeader := readCastHeader(path)
+		if errHeader != nil {
+			log.Warn("[driver.ListRecordings] Skipping invalid recording", "path", path, "error", errHeader)
+			continue
+		}
+		recordings = append(recordings, RecordingInfo{
+			ID:          id,
+			ContainerID: containerID,
+			Title:       header.Title,
+			StartedAt:   time.Unix(header.Timestamp, 0),
+			UpdatedAt:   info.ModTime(),
+			Size:        info.Size(),
+		})
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].StartedAt.After(recordings[j].StartedAt)
+	})
+	return recordings, nil
+}
+
+// Open a recording for download, must be closed by the caller
+func OpenRecording(containerID string, recordingID string) (*os.File, error) {
+	path, err := recordingPath(containerID, recordingID)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func DeleteRecording(containerID string, recordingID string) error {
+	path, err := recordingPath(containerID, recordingID)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func readCastHeader(path string) (*castHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	line, errRead := reader.ReadBytes('\n')
+	if errRead != nil && len(line) == 0 {
+		return nil, errRead
+	}
+	var header castHeader
+	if errJSON := json.Unmarshal(line, &header); errJSON != nil {
+		return nil, errJSON
+	}
+	if header.Version != 2 {
+		return nil, ErrInvalidRecording
+	}
+	return &header, nil
+}
+
+// Stream a recording to a console client respecting the original timing, `speed` scales the
+// timing and pauses are shortened to `idleLimit`. Input events are not replayed.
+func ReplayRecording(ctx context.Context, containerID string, recordingID string, conn *ConsoleConn, speed float64, idleLimit time.Duration) error {
+	if speed <= 0 {
+		return errors.New("speed must be positive")
+	}
+	path, err := recordingPath(containerID, recordingID)
+	if err != nil {
+		return err
+	}
+	file, errOpen := os.Open(path)
+	if errOpen != nil {
+		return errOpen
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		return ErrInvalidRecording
+	}
+	var header castHeader
+	if errJSON := json.Unmarshal(scanner.Bytes(), &header); errJSON != nil || header.Version != 2 {
+		return ErrInvalidRecording
+	}
+	if errWrite := conn.WriteMessage(&Message{Type: MessageResize, Width: header.Width, Height: header.Height}); errWrite != nil {
+		return errWrite
+	}
+
+	last := 0.0
+	for scanner.Scan() {
+		var event []json.RawMessage
+		if errJSON := json.Unmarshal(scanner.Bytes(), &event); errJSON != nil || len(event) != 3 {
+			return ErrInvalidRecording
+		}
+		var at float64
+		var code, data string
+		if json.Unmarshal(event[0], &at) != nil || json.Unmarshal(event[1], &code) != nil || json.Unmarshal(event[2], &data) != nil {
+			return ErrInvalidRecording
+		}
+
+		wait := min(time.Duration((at-last)*float64(time.Second)), idleLimit)
+		last = at
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(float64(wait) / speed)):
+		}
+
+		var msg *Message
+		switch code {
+		case "o":
+			msg = &Message{Type: MessageOutput, Data: []byte(data)}
+		case "r":
+			width, height, _ := strings.Cut(data, "x")
+			w, errW := strconv.ParseUint(width, 10, 0)
+			h, errH := strconv.ParseUint(height, 10, 0)
+			if errW != nil || errH != nil {
+				continue
+			}
+			msg = &Message{Type: MessageResize, Width: uint(w), Height: uint(h)}
+		default:
+			continue
+		}
+		if errWrite := conn.WriteMessage(msg); errWrite != nil {
+			return errWrite
+		}
+	}
+	if errScan := scanner.Err(); errScan != nil {
+		return errScan
+	}
+	exitCode := int64(0)
+	return conn.WriteMessage(&Message{Type: MessageExit, ExitCode: &exitCode})
+}
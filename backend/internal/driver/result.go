@@ -0,0 +1,166 @@
+package driver
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	// File the shell running the program writes its CPU times to
+	timesFile   = "/tmp/.webconsole-times"
+	markerFile  = "/tmp/.webconsole-build" // Printed to stderr once the build succeeded
+	waitTimeout = 30 * time.Second
+)
+
+// Result of a code execution
+type ExecutionResult struct {
+	Stdout          string       `json:"stdout"`
+	Stderr          string       `json:"stderr"`
+	ExitCode        int64        `json:"exit_code"`
+	WallTimeMs      int64        `json:"wall_time_ms"`
+	CPUTimeMs       int64        `json:"cpu_time_ms"` // User and system time, including the build step
+	OOMKilled       bool         `json:"oom_killed"`
+	TimedOut        bool         `json:"timed_out"`
+	StdoutTruncated bool         `json:"stdout_truncated"`
+	StderrTruncated bool         `json:"stderr_truncated"`
+	KilledReason    string       `json:"killed_reason,omitempty"` // Limit that got the program killed, if any
+	Diagnostics     []Diagnostic `json:"diagnostics,omitempty"`   // Errors and warnings parsed from stderr
+	// Install of the dependencies, the program doesn't run if it failed
+	Install *ExecutionResult `json:"install,omitempty"`
+}
+
+// The program exited with an error or was killed
+func (r *ExecutionResult) failed() bool {
+	return r.ExitCode != 0 || r.KilledReason != ""
+}
+
+// Keeps the output of an execution, its size is bounded by the output limit
+type outputCollector struct {
+	stdout, stderr []byte
+}
+
+func (c *outputCollector) handle(stream OutputStream, data []byte) {
+	if stream == StreamStderr {
+		c.stderr = append(c.stderr, data...)
+	} else {
+		c.stdout = append(c.stdout, data...)
+	}
+}
+
+func (c *outputCollector) fill(result *ExecutionResult) {
+	result.Stdout = string(c.stdout)
+	result.Stderr = string(c.stderr)
+}
+
+// Passes the output to a handler until the limit is reached, then calls `exceeded` once
+type outputLimiter struct {
+	handler  OutputHandler
+	limit    int
+	written  int
+	result   *ExecutionResult
+	exceeded func()
+}
+
+func (l *outputLimiter) handle(stream OutputStream, data []byte) {
+	if l.result.KilledReason == KilledOutput {
+		return
+	}
+	if l.limit > 0 && l.written+len(data) > l.limit {
+		if cut := utf8Boundary(data[:l.limit-l.written]); cut > 0 {
+			l.handler(stream, data[:cut])
+		}
+		l.written = l.limit
+		l.result.KilledReason = KilledOutput
+		if stream == StreamStderr {
+			l.result.StderrTruncated = true
+		} else {
+			l.result.StdoutTruncated = true
+		}
+		l.exceeded()
+		return
+	}
+	l.written += len(data)
+	l.handler(stream, data)
+}
+
+// Wrap a command so the shell running it writes the CPU times of its children once it exits
+func timedCommand(command string) string {
+	return command + "; status=$?; times > " + timesFile + "; exit $status"
+}
+
+// Wait for the container to exit and fill the exit code, timing and OOM flag of the result
+func inspectResult(containerID string, result *ExecutionResult) error {
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+
+	statusCh, errCh := dockerClient.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
+	select {
+	case errWait := <-errCh:
+		return errWait
+	case <-statusCh:
+	}
+
+	inspect, errInspect := dockerClient.ContainerInspect(ctx, containerID)
+	if errInspect != nil {
+		return errInspect
+	}
+	if inspect.State != nil {
+		result.ExitCode = int64(inspect.State.ExitCode)
+		result.OOMKilled = inspect.State.OOMKilled
+		if result.OOMKilled && result.KilledReason == "" {
+			result.KilledReason = KilledMemory
+		}
+		started, errStarted := time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+		finished, errFinished := time.Parse(time.RFC3339Nano, inspect.State.FinishedAt)
+		if errStarted == nil && errFinished == nil && finished.After(started) {
+			result.WallTimeMs = finished.Sub(started).Milliseconds()
+		}
+	}
+
+	cpuTime, errCPU := readCPUTime(ctx, containerID)
+	if errCPU != nil {
+		// The shell itself was killed, there's no CPU time to report
+		log.Debug("[driver.inspectResult] No CPU time for the execution", "error", errCPU)
+	}
+	result.CPUTimeMs = cpuTime.Milliseconds()
+	return nil
+}
+
+var timesRegex = regexp.MustCompile(`(\d+)m\s*([\d.]+)s`)
+
+// Read the output of `times` from the stopped container. The second line has the user and system
+// times of the children of the shell.
+func readCPUTime(ctx context.Context, containerID string) (time.Duration, error) {
+	reader, _, errCopy := dockerClient.CopyFromContainer(ctx, containerID, timesFile)
+	if errCopy != nil {
+		return 0, errCopy
+	}
+	defer reader.Close()
+	archive := tar.NewReader(reader)
+	if _, errHeader := archive.Next(); errHeader != nil {
+		return 0, errHeader
+	}
+	content, errRead := io.ReadAll(io.LimitReader(archive, 1024))
+	if errRead != nil {
+		return 0, errRead
+	}
+
+	times := timesRegex.FindAllStringSubmatch(string(content), -1)
+	if len(times) < 4 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	var total time.Duration
+	for _, match := range times[2:4] {
+		minutes, _ := strconv.Atoi(match[1])
+		seconds, _ := strconv.ParseFloat(match[2], 64)
+		total += time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second))
+	}
+	return total, nil
+}
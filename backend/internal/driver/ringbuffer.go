@@ -0,0 +1,58 @@
+package driver
+
+import "sync"
+
+// Fixed size byte buffer that keeps only the most recent bytes written to it
+type RingBuffer struct {
+	mu    sync.Mutex
+	buf   []byte
+	start int // Index of the oldest byte
+	size  int // Number of bytes stored
+}
+
+func NewRingBuffer(capacity int) *RingBuffer {
+	return &RingBuffer{buf: make([]byte, capacity)}
+}
+
+// Append p to the buffer, overwriting the oldest bytes when it's full. Never fails.
+func (r *RingBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := len(p)
+	capacity := len(r.buf)
+	if capacity == 0 {
+		return n, nil
+	}
+	// Only the tail of p can fit
+	if len(p) > capacity {
+		p = p[len(p)-capacity:]
+	}
+	end := (r.start + r.size) % capacity
+	copied := copy(r.buf[end:], p)
+	copy(r.buf, p[copied:])
+
+	r.size += len(p)
+	if r.size > capacity {
+		r.start = (r.start + r.size - capacity) % capacity
+		r.size = capacity
+	}
+	return n, nil
+}
+
+// Copy of the buffered bytes, oldest first
+func (r *RingBuffer) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]byte, r.size)
+	copied := copy(out, r.buf[r.start:min(r.start+r.size, len(r.buf))])
+	copy(out[copied:], r.buf[:r.size-copied])
+	return out
+}
+
+func (r *RingBuffer) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.size
+}
@@ -0,0 +1,40 @@
+package driver
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRingBuffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		writes   []string
+		want     string
+	}{
+		{"empty", 4, nil, ""},
+		{"fits", 8, []string{"abc", "de"}, "abcde"},
+		{"full", 4, []string{"ab", "cd"}, "abcd"},
+		{"overwrites oldest", 4, []string{"abc", "def"}, "cdef"},
+		{"wraps twice", 3, []string{"ab", "cd", "ef", "g"}, "efg"},
+		{"write bigger than capacity", 4, []string{"a", "bcdefgh"}, "efgh"},
+		{"zero capacity", 0, []string{"abc"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRingBuffer(tt.capacity)
+			for _, w := range tt.writes {
+				n, err := r.Write([]byte(w))
+				if err != nil || n != len(w) {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if got := r.Bytes(); !bytes.Equal(got, []byte(tt.want)) {
+				t.Errorf("Bytes() = %q, want %q", got, tt.want)
+			}
+			if r.Len() != len(tt.want) {
+				t.Errorf("Len() = %d, want %d", r.Len(), len(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,652 @@
+package driver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	DefaultDetachTimeout  = 5 * time.Minute
+	DefaultScrollbackSize = 64 * 1024
+	MaxExecSessions       = 8 // Max number of exec sessions per container
+	// Read only sessions are closed shortly after their client goes away
+	readOnlyDetachTimeout = 10 * time.Second
+)
+
+var (
+	ErrSessionForbidden  = errors.New("session belongs to another user")
+	ErrSessionNotFound   = errors.New("session not found")
+	ErrTooManySessions   = errors.New("too many sessions on container")
+	ErrContainerMismatch = errors.New("session belongs to another container")
+	ErrNotRunning        = errors.New("container is not running")
+)
+
+type SessionKind string
+
+const (
+	SessionAttach   SessionKind = "attach"   // Attached to the main process of the container
+	SessionExec     SessionKind = "exec"     // Process started with docker exec
+	SessionReadOnly SessionKind = "readonly" // Output of the main process, without input
+)
+
+// The process a session is attached to
+type sessionProcess interface {
+	Resize(ctx context.Context, width uint, height uint) error
+	// Blocks until the process exits, `streamDone` is closed once the output stream reached EOF
+	Wait(ctx context.Context, streamDone <-chan struct{}) (int64, error)
+	Stop(ctx context.Context) error
+}
+
+// Session metadata returned by the API
+type SessionInfo struct {
+	ID           string        `json:"id"`
+	ContainerID  string        `json:"containerid"`
+	Kind         SessionKind   `json:"kind"`
+	Command      []string      `json:"command"`
+	CreatedAt    time.Time     `json:"created_at"`
+	Participants []Participant `json:"participants"`
+}
+
+// Request to open a new exec session, `Command` defaults to the command of the container
+type SessionReq struct {
+	Command *string `json:"command"`
+	Width   uint    `json:"width"`
+	Height  uint    `json:"height"`
+}
+
+type SessionConfig struct {
+	DetachTimeout    time.Duration      // How long a session without clients is kept before stopping the process
+	ScrollbackSize   int                // Bytes of output kept to replay on reattach
+	ClientQueueBytes int                // Max bytes of output queued for a client
+	SlowConsumer     SlowConsumerPolicy // What to do when a client queue is full
+	PingInterval     time.Duration      // Interval of the keepalive pings sent to clients
+	WarningBefore    time.Duration      // How long before stopping a session the clients are warned
+	DefaultPolicy    SessionPolicy      // Used when no policy is configured for the user or image
+	TranscriptLines  int                // Lines of output kept for search and export
+	Audit            AuditSink          // Audit trail of the input, nil if disabled
+}
+
+// Keeps track of the console sessions alive on the server
+type SessionManager struct {
+	config SessionConfig
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	starting map[string]int // Exec sessions being started, by container
+}
+
+var Sessions *SessionManager
+
+// Init the session manager, must be call on server initialization
+func InitSessions(config SessionConfig) {
+	Sessions = &SessionManager{
+		config:   config,
+		sessions: map[string]*Session{},
+		starting: map[string]int{},
+	}
+}
+
+// A console session keeps the io stream of a process alive independently of the web socket
+// clients, so a client can go away and come back without stopping the process.
+type Session struct {
+	ID          string
+	Owner       string
+	ContainerID string
+	Kind        SessionKind
+	Command     []string
+	CreatedAt   time.Time
+
+	process       sessionProcess
+	stream        types.HijackedResponse
+	recorder      *Recorder // nil if the session isn't recorded
+	policy        SessionPolicy
+	scrollback    *RingBuffer
+	transcript    *Transcript
+	manager       *SessionManager
+	detachTimeout time.Duration // How long the session is kept without clients
+
+	mu          sync.Mutex
+	clients     map[*Client]struct{}
+	detachTimer *time.Timer
+	activeAt    time.Time // Last input or output
+	streamDone  chan struct{}
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+// Get the running session of the container, or attach to the container and create a new one
+func (m *SessionManager) Open(ctx context.Context, owner string, wc *WebContainer, logs bool, policy SessionPolicy) (*Session, error) {
+	if wc.Id == nil {
+		return nil, errors.New("Web container id not defined")
+	}
+	if session, ok, err := m.running(*wc.Id, owner); ok || err != nil {
+		return session, err
+	}
+
+	// Attaching can be slow, the lock isn't held meanwhile so other sessions aren't blocked
+	stream, errAttach := wc.AttachContainer(ctx, AttachConfig{Stdin: true, Logs: logs})
+	if errAttach != nil {
+		return nil, errAttach
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Another client might have opened the session while attaching, its session is kept
+	if session, ok := m.sessions[*wc.Id]; ok {
+		stream.Close()
+		if session.Owner != owner {
+			return nil, ErrSessionForbidden
+		}
+		return session, nil
+	}
+	session := m.newSession(*wc.Id, *wc.Id, owner, SessionAttach, []string{wc.Command}, &attachProcess{wc: wc}, stream, policy)
+	if wc.Record {
+		title := wc.Command
+		if wc.Name != nil {
+			title = *wc.Name
+		}
+		session.startRecording(title)
+	}
+	m.start(session)
+	return session, nil
+}
+
+// Running session of the container, `ok` is false if there is none
+func (m *SessionManager) running(containerID string, owner string) (session *Session, ok bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok = m.sessions[containerID]
+	if ok && session.Owner != owner {
+		return nil, true, ErrSessionForbidden
+	}
+	return session, ok, nil
+}
+
+// Start a new process with docker exec on the running container and open a session for it
+func (m *SessionManager) OpenExec(ctx context.Context, owner string, wc *WebContainer, cmd []string, width uint, height uint, policy SessionPolicy) (*Session, error) {
+	if wc.Id == nil {
+		return nil, errors.New("Web container id not defined")
+	}
+	containerID := *wc.Id
+	id, errID := newSessionID()
+	if errID != nil {
+		return nil, errID
+	}
+	if errReserve := m.reserveExec(containerID); errReserve != nil {
+		return nil, errReserve
+	}
+
+	// Creating and attaching the exec can be slow, the lock isn't held meanwhile so other sessions
+	// aren't blocked. The reserved slot keeps the limit.
+	process, stream, errExec := startExec(ctx, containerID, id, cmd, width, height)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.starting[containerID]--
+	if m.starting[containerID] == 0 {
+		delete(m.starting, containerID)
+	}
+	if errExec != nil {
+		return nil, errExec
+	}
+	session := m.newSession(id, containerID, owner, SessionExec, cmd, process, stream, policy)
+	if wc.Record {
+		session.startRecording(strings.Join(cmd, " "))
+	}
+	m.start(session)
+	return session, nil
+}
+
+// Reserve a slot for a new exec session of the container, ErrTooManySessions if it has the maximum
+// of exec sessions, counting the ones being started
+func (m *SessionManager) reserveExec(containerID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := m.starting[containerID]
+	for _, session := range m.sessions {
+		if session.ContainerID == containerID && session.Kind == SessionExec {
+			count++
+		}
+	}
+	if count >= MaxExecSessions {
+		return ErrTooManySessions
+	}
+	m.starting[containerID]++
+	return nil
+}
+
+// Attach to the main process of the container without input. Every call opens its own session, so
+// the same user can monitor the container from several clients. Closing the session never stops
+// the container, and a viewer never starts it: ErrNotRunning is returned if it isn't running.
+func (m *SessionManager) OpenReadOnly(ctx context.Context, owner string, wc *WebContainer, config AttachConfig) (*Session, error) {
+	if wc.Id == nil {
+		return nil, errors.New("Web container id not defined")
+	}
+	inspect, errInspect := dockerClient.ContainerInspect(ctx, *wc.Id)
+	if errInspect != nil {
+		return nil, errInspect
+	}
+	if inspect.State == nil || !inspect.State.Running {
+		return nil, ErrNotRunning
+	}
+	id, errID := newSessionID()
+	if errID != nil {
+		return nil, errID
+	}
+	config.Stdin = false
+	stream, errAttach := wc.AttachContainer(ctx, config)
+	if errAttach != nil {
+		return nil, errAttach
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session := m.newSession(id, *wc.Id, owner, SessionReadOnly, []string{wc.Command}, &readOnlyProcess{containerID: *wc.Id, stream: stream}, stream, SessionPolicy{})
+	session.detachTimeout = readOnlyDetachTimeout
+	m.start(session)
+	return session, nil
+}
+
+func (m *SessionManager) newSession(id string, containerID string, owner string, kind SessionKind, cmd []string, process sessionProcess, stream types.HijackedResponse, policy SessionPolicy) *Session {
+	now := time.Now()
+	return &Session{
+		ID:            id,
+		Owner:         owner,
+		ContainerID:   containerID,
+		Kind:          kind,
+		Command:       cmd,
+		CreatedAt:     now,
+		policy:        policy,
+		detachTimeout: m.config.DetachTimeout,
+		activeAt:      now,
+		process:       process,
+		stream:        stream,
+		scrollback:    NewRingBuffer(m.config.ScrollbackSize),
+		transcript:    NewTranscript(m.config.TranscriptLines),
+		manager:       m,
+		clients:       map[*Client]struct{}{},
+		streamDone:    make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// Register the session and start pumping its output. Must hold m.mu
+func (m *SessionManager) start(session *Session) {
+	m.sessions[session.ID] = session
+
+	go session.handleOutput()
+	go session.waitProcess()
+	go session.watchPolicy()
+	// Nobody might ever attach to this session
+	session.startDetachTimer()
+
+	log.Info("[SessionManager] New console session", "id", session.ID, "kind", session.Kind, "owner", session.Owner)
+}
+
+func (m *SessionManager) DefaultPolicy() SessionPolicy {
+	return m.config.DefaultPolicy
+}
+
+// Get a running session by its id
+func (m *SessionManager) Get(id string) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	session, ok := m.sessions[id]
+	return session, ok
+}
+
+// List the running sessions of a container
+func (m *SessionManager) List(containerID string) []SessionInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	infos := []SessionInfo{}
+	for _, session := range m.sessions {
+		if session.ContainerID == containerID {
+			infos = append(infos, session.Info())
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreatedAt.Before(infos[j].CreatedAt)
+	})
+	return infos
+}
+
+func (m *SessionManager) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+}
+
+// Attach a web socket client to the session and replay the scrollback. Blocks until the client
+// disconnects or the session ends. Every client gets the output of the session, only the ones with
+// a writable role can send input or resize the terminal.
+func (s *Session) Serve(wsConn *websocket.Conn, email string, ip string, role Role, width int, height int) {
+	client := newClient(email, ip, role, NewConsoleConn(wsConn), s.manager.config)
+	go client.writeLoop()
+	client.conn.Keepalive(s.manager.config.PingInterval, client.writerDone)
+
+	s.mu.Lock()
+	select {
+	case <-s.done:
+		s.mu.Unlock()
+		client.send(&Message{Type: MessageError, Error: "session closed"})
+		client.close()
+		<-client.writerDone
+		return
+	default:
+	}
+	if s.detachTimer != nil {
+		s.detachTimer.Stop()
+		s.detachTimer = nil
+	}
+	// The replay isn't subject to the queue limit, the scrollback might start in the middle of a
+	// UTF-8 sequence that was partially overwritten
+	if replay := trimUTF8Start(s.scrollback.Bytes()); len(replay) > 0 {
+		client.send(&Message{Type: MessageOutput, Data: replay})
+	}
+	s.clients[client] = struct{}{}
+	s.broadcastPresenceLocked()
+	s.mu.Unlock()
+
+	if role.CanWrite() && s.Kind != SessionReadOnly {
+		s.Resize(uint(width), uint(height))
+	}
+	s.handleInput(client)
+
+	s.mu.Lock()
+	client.close()
+	if _, ok := s.clients[client]; ok {
+		delete(s.clients, client)
+		s.broadcastPresenceLocked()
+		s.startDetachTimerLocked()
+	}
+	s.mu.Unlock()
+	<-client.writerDone
+}
+
+// Resize the tty of the session
+func (s *Session) Resize(width uint, height uint) error {
+	if err := s.process.Resize(context.Background(), width, height); err != nil {
+		return err
+	}
+	if s.recorder != nil {
+		s.recorder.Resize(width, height)
+	}
+	return nil
+}
+
+// Record the session to an asciicast file, the size is updated on the first resize
+func (s *Session) startRecording(title string) {
+	recorder, err := NewRecorder(s.ContainerID, s.ID, title, 80, 24)
+	if err != nil {
+		log.Error("[Session.startRecording] Error while creating the recording", "id", s.ID, "error", err)
+		return
+	}
+	s.recorder = recorder
+}
+
+// Stop the process of the session, the session ends once the process exits
+func (s *Session) Stop(ctx context.Context) error {
+	return s.process.Stop(ctx)
+}
+
+func (s *Session) Info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SessionInfo{
+		ID:           s.ID,
+		ContainerID:  s.ContainerID,
+		Kind:         s.Kind,
+		Command:      s.Command,
+		CreatedAt:    s.CreatedAt,
+		Participants: s.participantsLocked(),
+	}
+}
+
+// Text transcript of the output of the session
+func (s *Session) Transcript() *Transcript {
+	return s.transcript
+}
+
+// Done is closed once the session has ended
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Session) handleInput(client *Client) {
+	for {
+		message, err := client.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		switch message.Type {
+		case MessageInput:
+			if !s.canWrite(client) {
+				client.send(&Message{Type: MessageError, Error: ErrReadOnly.Error()})
+				continue
+			}
+			if errAudit := s.audit(client, message.Data); errAudit != nil {
+				client.send(&Message{Type: MessageError, Error: errAudit.Error()})
+				continue
+			}
+			s.touch()
+			s.stream.Conn.Write(message.Data)
+			if s.recorder != nil {
+				s.recorder.Input(message.Data)
+			}
+		case MessageResize:
+			if !s.canWrite(client) {
+				client.send(&Message{Type: MessageError, Error: ErrReadOnly.Error()})
+				continue
+			}
+			if errResize := s.Resize(message.Width, message.Height); errResize != nil {
+				client.send(&Message{Type: MessageError, Error: errResize.Error()})
+			}
+		case MessagePing:
+			client.send(&Message{Type: MessagePong})
+		default:
+			client.send(&Message{Type: MessageError, Error: ErrUnsupportedMessage.Error()})
+		}
+	}
+}
+
+// The role of a client can change while it's attached
+func (s *Session) canWrite(client *Client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return client.Role.CanWrite() && s.Kind != SessionReadOnly
+}
+
+// Read the process output and publish it to the scrollback and the attached clients. Chunks never
+// end in the middle of a UTF-8 sequence, the incomplete tail is kept for the next read.
+func (s *Session) handleOutput() {
+	defer close(s.streamDone)
+	bufPtr := outputBufferPool.Get().(*[]byte)
+	defer outputBufferPool.Put(bufPtr)
+	buf := *bufPtr
+
+	pending := 0
+	for {
+		n, err := s.stream.Reader.Read(buf[pending:])
+		if err != nil {
+			if pending > 0 {
+				s.publish(bytes.Clone(buf[:pending]))
+			}
+			return
+		}
+		total := pending + n
+		cut := utf8Boundary(buf[:total])
+		if cut > 0 {
+			s.publish(bytes.Clone(buf[:cut]))
+		}
+		pending = copy(buf, buf[cut:total])
+	}
+}
+
+// Send a chunk of output everywhere, `chunk` is shared and must not be modified
+func (s *Session) publish(chunk []byte) {
+	if s.recorder != nil {
+		s.recorder.Output(chunk)
+	}
+	s.transcript.Write(chunk)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.touchLocked()
+	s.scrollback.Write(chunk)
+	for client := range s.clients {
+		client.sendOutput(chunk)
+	}
+}
+
+// Wait for the process to exit and end the session
+func (s *Session) waitProcess() {
+	type result struct {
+		code int64
+		err  error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		code, err := s.process.Wait(context.Background(), s.streamDone)
+		resultCh <- result{code, err}
+	}()
+
+	var exitMsg *Message
+	select {
+	case res := <-resultCh:
+		if res.err != nil {
+			log.Error("[Session.waitProcess] Error while waiting for the process", "error", res.err)
+			exitMsg = &Message{Type: MessageError, Error: res.err.Error()}
+		} else {
+			exitMsg = &Message{Type: MessageExit, ExitCode: &res.code}
+		}
+	case <-s.done:
+		return
+	}
+	s.close(exitMsg)
+}
+
+func (s *Session) startDetachTimer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.startDetachTimerLocked()
+}
+
+// Stop the process if no client attaches before the detach timeout. Must hold s.mu
+func (s *Session) startDetachTimerLocked() {
+	if len(s.clients) > 0 || s.detachTimer != nil {
+		return
+	}
+	s.detachTimer = time.AfterFunc(s.detachTimeout, func() {
+		s.mu.Lock()
+		detached := len(s.clients) == 0
+		s.detachTimer = nil
+		s.mu.Unlock()
+		if detached {
+			log.Info("[Session] Detach timeout reached, stopping process", "id", s.ID)
+			if err := s.Stop(context.Background()); err != nil {
+				log.Error("[Session] Error while stopping the process", "id", s.ID, "error", err)
+			}
+		}
+	})
+}
+
+// End the session, sending msg to the attached clients
+func (s *Session) close(msg *Message) {
+	s.closeOnce.Do(func() {
+		s.manager.remove(s.ID)
+
+		s.mu.Lock()
+		close(s.done)
+		if s.detachTimer != nil {
+			s.detachTimer.Stop()
+		}
+		for client := range s.clients {
+			if msg != nil {
+				client.send(msg)
+			}
+			client.close()
+		}
+		clear(s.clients)
+		s.mu.Unlock()
+
+		s.stream.Close()
+		if s.recorder != nil {
+			s.recorder.Close()
+		}
+		log.Info("[Session.close] Console session closed", "id", s.ID)
+	})
+}
+
+// Main process of a container, stopping it stops the container
+type attachProcess struct {
+	wc *WebContainer
+}
+
+func (p *attachProcess) Resize(ctx context.Context, width uint, height uint) error {
+	return dockerClient.ContainerResize(ctx, *p.wc.Id, container.ResizeOptions{
+		Height: height,
+		Width:  width,
+	})
+}
+
+func (p *attachProcess) Wait(ctx context.Context, streamDone <-chan struct{}) (int64, error) {
+	statusCh, errWait := dockerClient.ContainerWait(ctx, *p.wc.Id, container.WaitConditionNotRunning)
+	select {
+	case err := <-errWait:
+		return 0, err
+	case status := <-statusCh:
+		return status.StatusCode, nil
+	}
+}
+
+func (p *attachProcess) Stop(ctx context.Context) error {
+	return p.wc.Close(ctx)
+}
+
+// Read only view of the main process of a container, stopping it only detaches from the container
+type readOnlyProcess struct {
+	containerID string
+	stream      types.HijackedResponse
+}
+
+func (p *readOnlyProcess) Resize(ctx context.Context, width uint, height uint) error {
+	return ErrReadOnly
+}
+
+func (p *readOnlyProcess) Wait(ctx context.Context, streamDone <-chan struct{}) (int64, error) {
+	<-streamDone
+	inspect, err := dockerClient.ContainerInspect(ctx, p.containerID)
+	if err != nil {
+		return 0, err
+	}
+	if inspect.State == nil || inspect.State.Running {
+		// The stream was closed by the server, the container is still running
+		return 0, nil
+	}
+	return int64(inspect.State.ExitCode), nil
+}
+
+func (p *readOnlyProcess) Stop(ctx context.Context) error {
+	p.stream.Close()
+	return nil
+}
+
+func newSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
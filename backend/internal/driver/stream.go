@@ -0,0 +1,51 @@
+package driver
+
+import (
+	"context"
+)
+
+// Output stream of a process
+type OutputStream string
+
+const (
+	StreamStdout OutputStream = "stdout"
+	StreamStderr OutputStream = "stderr"
+)
+
+// Receives the output of an execution as it's produced. `data` never ends in the middle of a UTF-8
+// sequence and is only valid during the call.
+type OutputHandler func(stream OutputStream, data []byte)
+
+// Adapts an OutputHandler to an io.Writer, holding back incomplete UTF-8 sequences
+type outputWriter struct {
+	stream  OutputStream
+	handler OutputHandler
+	pending []byte
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	data := p
+	if len(w.pending) > 0 {
+		data = append(w.pending, p...)
+	}
+	cut := utf8Boundary(data)
+	if cut > 0 {
+		w.handler(w.stream, data[:cut])
+	}
+	w.pending = append([]byte(nil), data[cut:]...)
+	return len(p), nil
+}
+
+// Send what's left, the process won't complete it
+func (w *outputWriter) flush() {
+	if len(w.pending) > 0 {
+		w.handler(w.stream, w.pending)
+		w.pending = nil
+	}
+}
+
+// Same as HandleExecution, but the output is passed to `handler` while the program runs instead of
+// being collected in the result
+func HandleExecutionStream(ctx context.Context, language Language, project Project, options ExecutionOptions, handler OutputHandler) (*ExecutionResult, error) {
+	return runWithDependencies(ctx, language, project, options, handler)
+}
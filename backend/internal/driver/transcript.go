@@ -0,0 +1,441 @@
+package driver
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	DefaultTranscriptLines = 10000
+	maxTranscriptLineWidth = 4096 // Longer lines are wrapped
+	MaxSearchContext       = 20
+	MaxSearchMatches       = 1000
+)
+
+// Colors are either -1 (default), a 256 color palette index, or a 24 bit color with colorRGB set
+const colorRGB = 1 << 24
+
+// Graphic rendition of a character, as set by SGR escape sequences
+type textStyle struct {
+	fg, bg    int32
+	bold      bool
+	italic    bool
+	underline bool
+}
+
+var defaultStyle = textStyle{fg: -1, bg: -1}
+
+// Run of characters sharing a style, `start` is a byte offset in the line
+type styleRun struct {
+	start int
+	style textStyle
+}
+
+type transcriptLine struct {
+	text string
+	runs []styleRun
+}
+
+type cell struct {
+	r     rune
+	style textStyle
+}
+
+// States of the escape sequence parser
+const (
+	stateText = iota
+	stateEscape
+	stateCSI
+	stateOSC
+	stateOSCEscape
+	stateCharset
+)
+
+// Bounded text transcript of a session output. Escape sequences are interpreted just enough to
+// keep colors and carriage returns, everything else is stripped.
+type Transcript struct {
+	mu       sync.Mutex
+	maxLines int
+	lines    []transcriptLine // Ring of committed lines
+	first    int              // Index in `lines` of the oldest line
+	dropped  int              // Number of lines dropped from the start
+
+	// Line being written
+	current []cell
+	col     int
+	style   textStyle
+
+	// Parser state
+	state   int
+	params  []byte
+	pending []byte // Incomplete UTF-8 sequence
+}
+
+func NewTranscript(maxLines int) *Transcript {
+	return &Transcript{maxLines: maxLines, style: defaultStyle}
+}
+
+// Feed output of the process to the transcript
+func (t *Transcript) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data := p
+	if len(t.pending) > 0 {
+		data = append(t.pending, p...)
+		t.pending = nil
+	}
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size <= 1 && !utf8.FullRune(data) {
+			t.pending = append([]byte(nil), data...)
+			break
+		}
+		data = data[size:]
+		t.feed(r)
+	}
+	return len(p), nil
+}
+
+func (t *Transcript) feed(r rune) {
+	switch t.state {
+	case stateEscape:
+		switch r {
+		case '[':
+			t.state = stateCSI
+			t.params = t.params[:0]
+		case ']':
+			t.state = stateOSC
+		case '(', ')', '*', '+':
+			t.state = stateCharset
+		default:
+			t.state = stateText
+		}
+	case stateCSI:
+		if r >= 0x40 && r <= 0x7e {
+			t.state = stateText
+			if r == 'm' {
+				t.applySGR(string(t.params))
+			} else if r == 'K' && (len(t.params) == 0 || string(t.params) == "0") {
+				// Erase to the end of the line
+				t.current = t.current[:min(t.col, len(t.current))]
+			}
+		} else if len(t.params) < 64 {
+			t.params = append(t.params, byte(r))
+		}
+	case stateOSC:
+		if r == 0x07 {
+			t.state = stateText
+		} else if r == 0x1b {
+			t.state = stateOSCEscape
+		}
+	case stateOSCEscape:
+		// ESC \ terminates the OSC
+		t.state = stateText
+	case stateCharset:
+		t.state = stateText
+	default:
+		t.feedText(r)
+	}
+}
+
+func (t *Transcript) feedText(r rune) {
+	switch r {
+	case 0x1b:
+		t.state = stateEscape
+	case '\n':
+		t.commitLine()
+	case '\r':
+		t.col = 0
+	case '\b':
+		if t.col > 0 {
+			t.col--
+		}
+	case '\t':
+		// put moves the column, the width of the tab is computed once
+		n := 8 - t.col%8
+		for i := 0; i < n; i++ {
+			t.put(' ')
+		}
+	default:
+		if r >= 0x20 && r != 0x7f {
+			t.put(r)
+		}
+	}
+}
+
+func (t *Transcript) put(r rune) {
+	if t.col >= maxTranscriptLineWidth {
+		t.commitLine()
+	}
+	c := cell{r: r, style: t.style}
+	if t.col < len(t.current) {
+		t.current[t.col] = c
+	} else {
+		t.current = append(t.current, c)
+	}
+	t.col++
+}
+
+func (t *Transcript) commitLine() {
+	line := compactLine(t.current)
+	t.current = t.current[:0]
+	t.col = 0
+
+	if t.maxLines <= 0 {
+		t.dropped++
+		return
+	}
+	if len(t.lines) < t.maxLines {
+		t.lines = append(t.lines, line)
+		return
+	}
+	t.lines[t.first] = line
+	t.first = (t.first + 1) % t.maxLines
+	t.dropped++
+}
+
+func compactLine(cells []cell) transcriptLine {
+	var text strings.Builder
+	runs := []styleRun{}
+	for _, c := range cells {
+		if len(runs) == 0 || runs[len(runs)-1].style != c.style {
+			runs = append(runs, styleRun{start: text.Len(), style: c.style})
+		}
+		text.WriteRune(c.r)
+	}
+	return transcriptLine{text: strings.TrimRight(text.String(), " "), runs: runs}
+}
+
+func (t *Transcript) applySGR(params string) {
+	if params == "" {
+		t.style = defaultStyle
+		return
+	}
+	codes := strings.Split(params, ";")
+	for i := 0; i < len(codes); i++ {
+		code, err := strconv.Atoi(codes[i])
+		if err != nil {
+			continue
+		}
+		switch {
+		case code == 0:
+			t.style = defaultStyle
+		case code == 1:
+			t.style.bold = true
+		case code == 3:
+			t.style.italic = true
+		case code == 4:
+			t.style.underline = true
+		case code == 22:
+			t.style.bold = false
+		case code == 23:
+			t.style.italic = false
+		case code == 24:
+			t.style.underline = false
+		case code >= 30 && code <= 37:
+			t.style.fg = int32(code - 30)
+		case code >= 90 && code <= 97:
+			t.style.fg = int32(code - 90 + 8)
+		case code == 39:
+			t.style.fg = -1
+		case code >= 40 && code <= 47:
+			t.style.bg = int32(code - 40)
+		case code >= 100 && code <= 107:
+			t.style.bg = int32(code - 100 + 8)
+		case code == 49:
+			t.style.bg = -1
+		case code == 38 || code == 48:
+			color, consumed := parseExtendedColor(codes[i+1:])
+			i += consumed
+			if code == 38 {
+				t.style.fg = color
+			} else {
+				t.style.bg = color
+			}
+		}
+	}
+}
+
+// Parse the arguments of a 38/48 SGR code, returns the color and the number of arguments used
+func parseExtendedColor(args []string) (int32, int) {
+	if len(args) >= 2 && args[0] == "5" {
+		index, err := strconv.Atoi(args[1])
+		if err != nil || index < 0 || index > 255 {
+			return -1, 2
+		}
+		return int32(index), 2
+	}
+	if len(args) >= 4 && args[0] == "2" {
+		var rgb [3]int
+		for i := range rgb {
+			value, err := strconv.Atoi(args[i+1])
+			if err != nil || value < 0 || value > 255 {
+				return -1, 4
+			}
+			rgb[i] = value
+		}
+		return int32(colorRGB | rgb[0]<<16 | rgb[1]<<8 | rgb[2]), 4
+	}
+	return -1, len(args)
+}
+
+// Snapshot of the lines, including the one being written. Returns the number of the first line.
+func (t *Transcript) snapshot() ([]transcriptLine, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lines := make([]transcriptLine, 0, len(t.lines)+1)
+	lines = append(lines, t.lines[t.first:]...)
+	lines = append(lines, t.lines[:t.first]...)
+	if len(t.current) > 0 {
+		lines = append(lines, compactLine(t.current))
+	}
+	return lines, t.dropped
+}
+
+// A search query over a transcript
+type TranscriptQuery struct {
+	Pattern    string
+	Regex      bool
+	IgnoreCase bool
+	Context    int // Lines of context before and after each match
+}
+
+// A line matching a search, line numbers start at 1 with the first line of the session
+type TranscriptMatch struct {
+	Line   int      `json:"line"`
+	Text   string   `json:"text"`
+	Before []string `json:"before"`
+	After  []string `json:"after"`
+}
+
+// Search the transcript line by line
+func (t *Transcript) Search(query TranscriptQuery) ([]TranscriptMatch, error) {
+	pattern := query.Pattern
+	if !query.Regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if query.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, errRegex := regexp.Compile(pattern)
+	if errRegex != nil {
+		return nil, errRegex
+	}
+	contextLines := min(max(query.Context, 0), MaxSearchContext)
+
+	lines, firstLine := t.snapshot()
+	matches := []TranscriptMatch{}
+	for i, line := range lines {
+		if !re.MatchString(line.text) {
+			continue
+		}
+		match := TranscriptMatch{
+			Line:   firstLine + i + 1,
+			Text:   line.text,
+			Before: []string{},
+			After:  []string{},
+		}
+		for _, before := range lines[max(i-contextLines, 0):i] {
+			match.Before = append(match.Before, before.text)
+		}
+		for _, after := range lines[i+1 : min(i+1+contextLines, len(lines))] {
+			match.After = append(match.After, after.text)
+		}
+		matches = append(matches, match)
+		if len(matches) >= MaxSearchMatches {
+			break
+		}
+	}
+	return matches, nil
+}
+
+// Transcript as plain text
+func (t *Transcript) Text() string {
+	lines, _ := t.snapshot()
+	var builder strings.Builder
+	for _, line := range lines {
+		builder.WriteString(line.text)
+		builder.WriteByte('\n')
+	}
+	return builder.String()
+}
+
+// Transcript as a standalone HTML document keeping the colors of the output
+func (t *Transcript) HTML(title string) string {
+	lines, _ := t.snapshot()
+	var builder strings.Builder
+	builder.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>")
+	builder.WriteString(html.EscapeString(title))
+	builder.WriteString("</title></head>\n<body style=\"background:#1e1e2e;color:#cdd6f4\"><pre style=\"font-family:monospace\">\n")
+	for _, line := range lines {
+		for i, run := range line.runs {
+			if run.start >= len(line.text) {
+				break
+			}
+			end := len(line.text)
+			if i+1 < len(line.runs) {
+				end = min(line.runs[i+1].start, end)
+			}
+			text := html.EscapeString(line.text[run.start:end])
+			if css := run.style.css(); css != "" {
+				builder.WriteString(`<span style="` + css + `">` + text + "</span>")
+			} else {
+				builder.WriteString(text)
+			}
+		}
+		builder.WriteByte('\n')
+	}
+	builder.WriteString("</pre></body></html>\n")
+	return builder.String()
+}
+
+// The 16 basic colors, the rest of the 256 color palette is computed
+var basicColors = [16]string{
+	"#45475a", "#f38ba8", "#a6e3a1", "#f9e2af", "#89b4fa", "#f5c2e7", "#94e2d5", "#bac2de",
+	"#585b70", "#f38ba8", "#a6e3a1", "#f9e2af", "#89b4fa", "#f5c2e7", "#94e2d5", "#a6adc8",
+}
+
+func cssColor(color int32) string {
+	switch {
+	case color < 0:
+		return ""
+	case color&colorRGB != 0:
+		return fmt.Sprintf("#%06x", color&0xffffff)
+	case color < 16:
+		return basicColors[color]
+	case color < 232:
+		// 6x6x6 color cube
+		levels := [6]int{0, 95, 135, 175, 215, 255}
+		index := int(color) - 16
+		return fmt.Sprintf("#%02x%02x%02x", levels[index/36], levels[index/6%6], levels[index%6])
+	default:
+		gray := 8 + (int(color)-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", gray, gray, gray)
+	}
+}
+
+func (s textStyle) css() string {
+	var css []string
+	if fg := cssColor(s.fg); fg != "" {
+		css = append(css, "color:"+fg)
+	}
+	if bg := cssColor(s.bg); bg != "" {
+		css = append(css, "background:"+bg)
+	}
+	if s.bold {
+		css = append(css, "font-weight:bold")
+	}
+	if s.italic {
+		css = append(css, "font-style:italic")
+	}
+	if s.underline {
+		css = append(css, "text-decoration:underline")
+	}
+	return strings.Join(css, ";")
+}
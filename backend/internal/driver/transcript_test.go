@@ -0,0 +1,49 @@
+package driver
+
+import "testing"
+
+func TestTranscriptText(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"empty", nil, ""},
+		{"lines", []string{"a\nb\n"}, "a\nb\n"},
+		{"unterminated line", []string{"a\nb"}, "a\nb\n"},
+		{"tab at start", []string{"\tx\n"}, "        x\n"},
+		{"tab after text", []string{"abc\tx\n"}, "abc     x\n"},
+		{"tab at a stop", []string{"abcdefgh\tx\n"}, "abcdefgh        x\n"},
+		{"tabs", []string{"a\tb\tc\n"}, "a       b       c\n"},
+		{"carriage return overwrites", []string{"hello\rHE\n"}, "HEllo\n"},
+		{"backspace", []string{"ab\bc\n"}, "ac\n"},
+		{"colors stripped", []string{"\x1b[1;31mred\x1b[0m\n"}, "red\n"},
+		{"erase to end of line", []string{"hello\r\x1b[Kbye\n"}, "bye\n"},
+		{"osc stripped", []string{"\x1b]0;title\x07text\n"}, "text\n"},
+		{"sequence split across writes", []string{"\xe2\x82", "\xac\n"}, "€\n"},
+		{"trailing spaces trimmed", []string{"a   \n"}, "a\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transcript := NewTranscript(100)
+			for _, w := range tt.writes {
+				transcript.Write([]byte(w))
+			}
+			if got := transcript.Text(); got != tt.want {
+				t.Errorf("Text() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranscriptMaxLines(t *testing.T) {
+	transcript := NewTranscript(2)
+	transcript.Write([]byte("1\n2\n3\n4\n"))
+	if got := transcript.Text(); got != "3\n4\n" {
+		t.Errorf("Text() = %q, want %q", got, "3\n4\n")
+	}
+	matches, err := transcript.Search(TranscriptQuery{Pattern: "4"})
+	if err != nil || len(matches) != 1 || matches[0].Line != 4 {
+		t.Errorf("Search() = %v, %v, want line 4", matches, err)
+	}
+}
@@ -1,18 +1,20 @@
 package driver
 
 import (
+	"bufio"
 	"bytes"
 	"context"
-	"encoding/base64"
 	"errors"
 	"io"
-	"net"
 	"strings"
 
 	"github.com/charmbracelet/log"
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
-	"github.com/gorilla/websocket"
+	"github.com/docker/docker/errdefs"
 )
 
 var dockerClient *client.Client
@@ -31,12 +33,19 @@ type ImageType string
 // A container instance
 type WebContainer struct {
 	Command       string    // Command to run in the container
+	Args          []string  // Appended to the command as they are, after `/bin/sh -c` the first one is `$0`
 	Image         ImageType // Image to use for the container
 	AttachIO      bool      // Attach IO to the container
 	AutoRemove    bool      // If we should autoremove when the container stops
 	Name          *string   // Optional name for the container
 	Id            *string   // The id of the container, either provided or generated
 	NetworkEnable bool
+	Record        bool             // Record the console sessions of the container
+	StdinOnce     bool             // Open stdin for a single attach, the process reads EOF once it's closed
+	Limits        *ExecutionLimits // Resource limits, nil for none
+	Labels        map[string]string
+	Env           []string      // Environment variables, `KEY=value`
+	Mounts        []mount.Mount // Volumes mounted in the container
 }
 
 // Create the container and return the id
@@ -56,20 +65,28 @@ func (wc *WebContainer) Create(ctx context.Context) (*string, error) {
 	}
 
 	log.Info("[WebContainer.Create]", "command", cmd)
+	cmd = append(cmd, wc.Args...)
 
 	containerConfig := container.Config{
 		Image:           string(wc.Image),
-		AttachStdin:     wc.AttachIO,
+		AttachStdin:     wc.AttachIO || wc.StdinOnce,
 		AttachStderr:    wc.AttachIO,
 		AttachStdout:    wc.AttachIO,
-		OpenStdin:       wc.AttachIO,
+		OpenStdin:       wc.AttachIO || wc.StdinOnce,
+		StdinOnce:       wc.StdinOnce,
 		Tty:             wc.AttachIO,
 		NetworkDisabled: !wc.NetworkEnable,
 		Cmd:             cmd,
+		Labels:          wc.Labels,
+		Env:             wc.Env,
 	}
 
 	hostConfig := container.HostConfig{
 		AutoRemove: wc.AutoRemove,
+		Mounts:     wc.Mounts,
+	}
+	if wc.Limits != nil {
+		hostConfig.Resources = wc.Limits.resources()
 	}
 	if wc.Name != nil {
 		containerName = *wc.Name
@@ -118,63 +135,57 @@ func (wc *WebContainer) Close(ctx context.Context) error {
 	return nil
 }
 
-// Attachs the websocket streams to the container io streams of the main running process (configured on container creation)
-func (wc *WebContainer) AttachContainer(ctx context.Context, resize bool, wsConn *websocket.Conn, logs bool, width int, height int) error {
+// Options of an attach to the main process of a container
+type AttachConfig struct {
+	Stdin bool   // Forward input to the process, disabled for read only attachs
+	Logs  bool   // Replay the output of the container from the start
+	Since string // Only replay the output since a timestamp or a relative duration (e.g. 10m)
+	Tail  string // Only replay the last lines of the output (e.g. 100)
+}
+
+// Max bytes of previous output replayed when attaching with `Since` or `Tail`
+const maxAttachLogs = 4 * 1024 * 1024
+
+// Attachs to the io streams of the main running process (configured on container creation). The
+// returned stream must be closed by the caller.
+func (wc *WebContainer) AttachContainer(ctx context.Context, config AttachConfig) (types.HijackedResponse, error) {
+	if wc.Id == nil {
+		return types.HijackedResponse{}, errors.New("Web container id not defined")
+	}
+	// Docker can only replay the whole output on attach, a partial replay goes through the logs
+	partialLogs := config.Since != "" || config.Tail != ""
 	attachOptions := container.AttachOptions{
-		Stdin:  true,
+		Stdin:  config.Stdin,
 		Stdout: true,
 		Stderr: true,
 		Stream: true,
-		Logs:   logs,
-	}
-
-	resp, errAttach := dockerClient.ContainerAttach(ctx, *wc.Id, attachOptions)
-	defer resp.Close()
-	if resize {
-		dockerClient.ContainerResize(ctx, *wc.Id, container.ResizeOptions{
-			Height: uint(height),
-			Width:  uint(width),
-		})
-	}
-
-	if errAttach != nil {
-		return errAttach
+		Logs:   config.Logs && !partialLogs,
 	}
-	go handleInput(resp.Conn, wsConn)
-	go handleOutput(resp.Conn, wsConn)
 
-	// Wait for container to stop
-	statusCh, errWait := dockerClient.ContainerWait(ctx, *wc.Id, container.WaitConditionNotRunning)
-	select {
-	case err := <-errWait:
-		if err != nil {
-			return err
-		}
-	case <-statusCh:
+	stream, errAttach := dockerClient.ContainerAttach(ctx, *wc.Id, attachOptions)
+	if errAttach != nil || !partialLogs {
+		return stream, errAttach
 	}
-	return nil
-}
 
-func handleInput(conn net.Conn, wsConn *websocket.Conn) {
-	for {
-		_, message, err := wsConn.ReadMessage()
-		if err != nil {
-			break
-		}
-		conn.Write(message)
+	// Attach first so no output is lost between the logs and the stream
+	logs, errLogs := dockerClient.ContainerLogs(ctx, *wc.Id, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Since:      config.Since,
+		Tail:       config.Tail,
+	})
+	if errLogs != nil {
+		stream.Close()
+		return types.HijackedResponse{}, errLogs
 	}
-}
-
-func handleOutput(conn net.Conn, wsOut *websocket.Conn) {
-	for {
-		buf := make([]byte, 1024)
-		n, err := conn.Read(buf)
-		if err != nil {
-			return
-		}
-		strEncode := base64.StdEncoding.EncodeToString(buf[:n])
-		wsOut.WriteMessage(websocket.TextMessage, []byte(strEncode))
+	defer logs.Close()
+	previous, errRead := io.ReadAll(io.LimitReader(logs, maxAttachLogs))
+	if errRead != nil {
+		stream.Close()
+		return types.HijackedResponse{}, errRead
 	}
+	stream.Reader = bufio.NewReader(io.MultiReader(bytes.NewReader(previous), stream.Reader))
+	return stream, nil
 }
 
 // Remove the given container
@@ -184,37 +195,54 @@ func (w *WebContainer) RemoveContainer(ctx context.Context) {
 	}
 }
 
-// Copy a file to the given container and start the container. Finally get container logs.
-func (wc *WebContainer) CopyFileAndStart(ctx context.Context, path string, buf *bytes.Buffer) ([]byte, error) {
+// Extract a tar archive in the given directory of the container
+func (wc *WebContainer) CopyFiles(ctx context.Context, path string, buf *bytes.Buffer) error {
 	if wc.Id == nil {
-		return nil, errors.New("Container id is nil")
+		return errors.New("Container id is nil")
 	}
-	copyErr := dockerClient.CopyToContainer(ctx, *wc.Id, path, buf, container.CopyToContainerOptions{
+	return dockerClient.CopyToContainer(ctx, *wc.Id, path, buf, container.CopyToContainerOptions{
 		AllowOverwriteDirWithFile: false,
 	})
-	if copyErr != nil {
-		return nil, copyErr
-	}
+}
 
-	errStart := wc.Start(ctx)
-	if errStart != nil {
-		return nil, errStart
-	}
+// Repository of the images committed from code containers, they are removed once used
+const commitRepository = "webconsole-commit"
 
-	// Get logs
-	reader, errLogs := dockerClient.ContainerLogs(ctx, *wc.Id, container.LogsOptions{
-		Follow:     true, // Follow idk why this is needed
-		ShowStdout: true,
-		ShowStderr: true,
-	})
-	if errLogs != nil {
-		return nil, errLogs
+// Commit the container to a new image, returns its reference
+func commitContainer(ctx context.Context, containerID string) (string, error) {
+	tag, errID := newSessionID()
+	if errID != nil {
+		return "", errID
+	}
+	reference := commitRepository + ":" + tag
+	_, errCommit := dockerClient.ContainerCommit(ctx, containerID, container.CommitOptions{Reference: reference})
+	if errCommit != nil {
+		return "", errCommit
 	}
+	return reference, nil
+}
 
-	// Read to buff
-	bufLogs, errRead := io.ReadAll(reader)
-	if errRead != nil {
-		return nil, errRead
+// Remove an image created by commitContainer
+func removeImage(reference string) {
+	_, errRemove := dockerClient.ImageRemove(context.Background(), reference, image.RemoveOptions{Force: true, PruneChildren: true})
+	if errRemove != nil {
+		log.Warn("[driver.removeImage] Error while removing a committed image", "image", reference, "error", errRemove)
 	}
-	return bufLogs, nil
+}
+
+// Pull the image if it isn't available locally. Images built by `images/build.sh` can't be pulled.
+func EnsureImage(ctx context.Context, ref string) error {
+	_, _, errInspect := dockerClient.ImageInspectWithRaw(ctx, ref)
+	if errInspect == nil || !errdefs.IsNotFound(errInspect) {
+		return errInspect
+	}
+	log.Info("[driver.EnsureImage] Pulling image", "image", ref)
+	reader, errPull := dockerClient.ImagePull(ctx, ref, image.PullOptions{})
+	if errPull != nil {
+		return errPull
+	}
+	defer reader.Close()
+	// The pull is done once the progress stream ends
+	_, errRead := io.Copy(io.Discard, reader)
+	return errRead
 }
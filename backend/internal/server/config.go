@@ -0,0 +1,85 @@
+package server
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Read a string from the environment, returns `def` if unset
+func envString(name string, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+// Read a duration (e.g. `5m`, `30s`) from the environment, returns `def` if unset or invalid
+func envDuration(name string, def time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Warn("[server.envDuration] Invalid duration, using default", "variable", name, "value", raw, "default", def)
+		return def
+	}
+	return value
+}
+
+// Read an integer from the environment, returns `def` if unset or invalid
+func envInt(name string, def int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		log.Warn("[server.envInt] Invalid integer, using default", "variable", name, "value", raw, "default", def)
+		return def
+	}
+	return value
+}
+
+// Read a boolean (e.g. `true`, `1`) from the environment, returns `def` if unset or invalid
+func envBool(name string, def bool) bool {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Warn("[server.envBool] Invalid boolean, using default", "variable", name, "value", raw, "default", def)
+		return def
+	}
+	return value
+}
+
+// Read a decimal number from the environment, returns `def` if unset or invalid
+func envFloat(name string, def float64) float64 {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		log.Warn("[server.envFloat] Invalid number, using default", "variable", name, "value", raw, "default", def)
+		return def
+	}
+	return value
+}
+
+// Read a comma separated list from the environment, empty items are skipped
+func envList(name string) []string {
+	items := []string{}
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
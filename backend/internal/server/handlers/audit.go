@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AlvaroParker/web-console/internal/database"
+	"github.com/charmbracelet/log"
+)
+
+// Query the audit log of the terminal input, oldest first. Query parameters, all optional:
+// - email, container, session: exact match filters
+// - from, to: RFC 3339 timestamps, `to` is exclusive
+// - limit (max 1000), offset: pagination
+//
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request
+// - 401: Unauthorized
+// - 403: Forbidden
+// - 500: Internal Server Error
+func QueryAudit(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.QueryAudit] Request received")
+	if status := authAdmin(request); status != http.StatusOK {
+		writer.WriteHeader(status)
+		return
+	}
+	filter, errFilter := auditFilter(request)
+	if errFilter != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	records, errDB := database.QueryAudit(filter)
+	if errDB != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.QueryAudit] Error while querying the audit log", "error", errDB)
+		return
+	}
+	jsonRecords, errJSON := json.Marshal(records)
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.QueryAudit] Error while marshalling the audit log", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonRecords)
+}
+
+// Export the audit log with the same filters as `QueryAudit`, without pagination. The `format`
+// query parameter is either `jsonl` (default, one entry per line) or `csv`.
+//
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request
+// - 401: Unauthorized
+// - 403: Forbidden
+func ExportAudit(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.ExportAudit] Request received")
+	if status := authAdmin(request); status != http.StatusOK {
+		writer.WriteHeader(status)
+		return
+	}
+	filter, errFilter := auditFilter(request)
+	if errFilter != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// The export can take longer than the write timeout of the server
+	http.NewResponseController(writer).SetWriteDeadline(time.Time{})
+
+	var errExport error
+	filename := "audit-" + time.Now().UTC().Format("20060102T150405Z")
+	switch request.URL.Query().Get("format") {
+	case "", "jsonl":
+		writer.Header().Add("Content-Type", "application/x-ndjson")
+		writer.Header().Add("Content-Disposition", `attachment; filename="`+filename+`.jsonl"`)
+		encoder := json.NewEncoder(writer)
+		errExport = database.ExportAudit(filter, func(record database.AuditRecord) error {
+			return encoder.Encode(record)
+		})
+	case "csv":
+		writer.Header().Add("Content-Type", "text/csv; charset=utf-8")
+		writer.Header().Add("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+		csvWriter := csv.NewWriter(writer)
+		csvWriter.Write([]string{"id", "created_at", "email", "containerid", "sessionid", "client_ip", "input", "prev_hash", "hash"})
+		errExport = database.ExportAudit(filter, func(record database.AuditRecord) error {
+			return csvWriter.Write([]string{
+				strconv.FormatInt(record.ID, 10),
+				record.CreatedAt.Format(time.RFC3339Nano),
+				record.Email,
+				record.ContainerID,
+				record.SessionID,
+				record.ClientIP,
+				record.Input,
+				record.PrevHash,
+				record.Hash,
+			})
+		})
+		csvWriter.Flush()
+	default:
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// The headers are already sent, the export is just cut short
+	if errExport != nil {
+		log.Error("[handlers.ExportAudit] Error while exporting the audit log", "error", errExport)
+	}
+}
+
+// Verify the hash chain of the audit log
+//
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 403: Forbidden
+// - 500: Internal Server Error
+func VerifyAudit(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.VerifyAudit] Request received")
+	if status := authAdmin(request); status != http.StatusOK {
+		writer.WriteHeader(status)
+		return
+	}
+	verification, errVerify := database.VerifyAudit()
+	if errVerify != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.VerifyAudit] Error while verifying the audit log", "error", errVerify)
+		return
+	}
+	jsonVerification, errJSON := json.Marshal(verification)
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.VerifyAudit] Error while marshalling the verification", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonVerification)
+}
+
+// Purge the entries of the audit log created before the `before` query parameter (RFC 3339)
+//
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request
+// - 401: Unauthorized
+// - 403: Forbidden
+// - 500: Internal Server Error
+func PurgeAudit(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.PurgeAudit] Request received")
+	if status := authAdmin(request); status != http.StatusOK {
+		writer.WriteHeader(status)
+		return
+	}
+	before, errBefore := time.Parse(time.RFC3339, request.URL.Query().Get("before"))
+	if errBefore != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	purged, errPurge := database.PurgeAudit(before)
+	if errPurge != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.PurgeAudit] Error while purging the audit log", "error", errPurge)
+		return
+	}
+	jsonPurged, errJSON := json.Marshal(map[string]int64{"purged": purged})
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonPurged)
+}
+
+// Check that the request comes from an administrator, returns the HTTP status to answer otherwise
+func authAdmin(request *http.Request) int {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		return http.StatusUnauthorized
+	}
+	isAdmin, errAdmin := database.IsAdmin(email)
+	if errAdmin != nil {
+		log.Error("[handlers.authAdmin] Error while checking the user", "error", errAdmin)
+		return http.StatusInternalServerError
+	}
+	if !isAdmin {
+		return http.StatusForbidden
+	}
+	return http.StatusOK
+}
+
+func auditFilter(request *http.Request) (database.AuditFilter, error) {
+	query := request.URL.Query()
+	filter := database.AuditFilter{
+		Email:       query.Get("email"),
+		ContainerID: query.Get("container"),
+		SessionID:   query.Get("session"),
+	}
+	var err error
+	if raw := query.Get("from"); raw != "" {
+		if filter.From, err = time.Parse(time.RFC3339, raw); err != nil {
+			return filter, err
+		}
+	}
+	if raw := query.Get("to"); raw != "" {
+		if filter.To, err = time.Parse(time.RFC3339, raw); err != nil {
+			return filter, err
+		}
+	}
+	if raw := query.Get("limit"); raw != "" {
+		if filter.Limit, err = strconv.Atoi(raw); err != nil {
+			return filter, err
+		}
+	}
+	if raw := query.Get("offset"); raw != "" {
+		if filter.Offset, err = strconv.Atoi(raw); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
@@ -3,42 +3,430 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/AlvaroParker/web-console/internal/database"
 	"github.com/AlvaroParker/web-console/internal/driver"
 	"github.com/charmbracelet/log"
 )
 
+// Run the code and answer with the result once it exits: stdout and stderr, exit code, wall and CPU
+// time, and flags for OOM kills, timeouts and truncated output.
+//
+// The body is either JSON with a single `code` file or a `files` tree of path and content pairs, or
+// a multipart form with the same fields and the tree uploaded as an `archive` file (zip, tar or
+// tar.gz). `entrypoint` is the file to run, relative to the source directory of the language.
+//
+// `args` are the arguments of the program and `env` an object of variables, `arg` and `env` form
+// fields repeated for each of them with variables as `NAME=value`. They never go through a shell:
+// the arguments are passed as they are and the run command of the language passes them as `"$@"`,
+// the variables are set on the container and only the run command sees them, not the build or the
+// dependency install. Variables that would break the commands or change a toolchain, such as `PATH`,
+// `LD_PRELOAD` or `RUSTFLAGS`, are a Bad Request.
+//
+// The execution is stored in the history of the user, see `GET /code/executions`.
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request, also for unsupported languages
+// - 401: Unauthorized
+// - 409: Conflict, the execution was cancelled
+// - 429: Too Many Requests, the user has too many queued executions
+// - 500: Internal Server Error
+// - 503: Service Unavailable, the execution queue is full
 func PostCodeHandler(writer http.ResponseWriter, request *http.Request) {
-	_, errAuth := database.Middleware(request)
+	email, errAuth := database.Middleware(request)
 	if errAuth != nil {
 		writer.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-	ctx := context.Background()
+	codeReq, errDecode := decodeCodeReq(request)
+	if errDecode != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	language, status := codeLanguage(*codeReq.Language)
+	if language == nil {
+		writer.WriteHeader(status)
+		return
+	}
+	project, errProject := codeReq.Project(*language)
+	if errProject != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
-	var codeReq driver.CodeReq
-	jsonErr := json.NewDecoder(request.Body).Decode(&codeReq)
-	if jsonErr != nil {
+	runCode(writer, request, email, *language, project, codeReq.Stdin)
+}
+
+// Run the project through the execution queue and answer with the result once it exits. The
+// `Location` header is the URL of the execution in the history of the user.
+func runCode(writer http.ResponseWriter, request *http.Request, email string, language driver.Language, project driver.Project, stdin *string) {
+	// The execution can wait in the queue for longer than the write timeout of the server
+	http.NewResponseController(writer).SetWriteDeadline(time.Time{})
+	var executionID int64
+	job, errSubmit := driver.SubmitJob(email, executeCode(email, language, project, stdin, &executionID))
+	if errSubmit != nil {
+		writer.WriteHeader(queueStatus(errSubmit))
+		return
+	}
+	job, status := waitJob(request, job, email)
+	if status != http.StatusOK {
+		writer.WriteHeader(status)
+		return
+	}
+	jsonResult, errJSON := json.Marshal(job.Result)
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.runCode] Error while marshalling the result", "error", errJSON)
+		return
+	}
+	if executionID != 0 {
+		writer.Header().Add("Location", "/code/executions/"+strconv.FormatInt(executionID, 10))
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonResult)
+}
+
+// Job running the project with the limits of the user and storing the execution in the history of
+// the user. `executionID` is set to the id of the stored execution.
+func executeCode(email string, language driver.Language, project driver.Project, stdin *string, executionID *int64) func(ctx context.Context) (*driver.ExecutionResult, error) {
+	return func(ctx context.Context) (*driver.ExecutionResult, error) {
+		options := driver.ExecutionOptions{Limits: executionLimits(email, language.Name), User: email}
+		if stdin != nil {
+			options.Stdin = strings.NewReader(*stdin)
+		}
+		result, errExec := driver.HandleExecution(ctx, language, project, options)
+		if errExec != nil {
+			log.Error("[handlers.executeCode] Error while executing the code", "error", errExec)
+			return nil, errExec
+		}
+		id, errHistory := database.AddExecution(email, language.Name, project, stdin, result)
+		if errHistory != nil {
+			// The result is still returned, only the history misses it
+			log.Error("[handlers.executeCode] Error while storing the execution", "error", errHistory)
+		} else if executionID != nil {
+			*executionID = id
+		}
+		return result, nil
+	}
+}
+
+// Wait for a job of the user to finish, returns the HTTP status to answer if it isn't done: 409 if
+// it was cancelled, 500 if it failed. The request context is cancelled when the client goes away,
+// which cancels the job.
+func waitJob(request *http.Request, job driver.Job, email string) (driver.Job, int) {
+	job, errWait := driver.WaitJob(request.Context(), job.ID, email)
+	switch {
+	case errWait != nil:
+		log.Error("[handlers.waitJob] Error while waiting for the execution", "error", errWait)
+		return job, http.StatusInternalServerError
+	case job.Status == driver.JobCancelled:
+		return job, http.StatusConflict
+	case job.Status != driver.JobDone:
+		log.Error("[handlers.waitJob] The execution failed", "job", job.ID, "error", job.Error)
+		return job, http.StatusInternalServerError
+	}
+	return job, http.StatusOK
+}
+
+// HTTP status of a submission rejected by the execution queue
+func queueStatus(err error) int {
+	switch {
+	case errors.Is(err, driver.ErrUserQueueFull):
+		return http.StatusTooManyRequests
+	case errors.Is(err, driver.ErrQueueFull):
+		return http.StatusServiceUnavailable
+	}
+	log.Error("[handlers.queueStatus] Error while queueing the execution", "error", err)
+	return http.StatusInternalServerError
+}
+
+var (
+	errInvalidCodeReq      = errors.New("invalid code request")
+	errUnsupportedLanguage = errors.New("unsupported language")
+)
+
+// Event sent by `StreamCodeHandler`
+type codeEvent struct {
+	Data  string `json:"data,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Run the code and stream its output with server sent events while the program runs, the body is
+// the same as `POST /code`. The events are:
+// - `stdout` and `stderr`: `{"data": "..."}`, a chunk of output
+// - `exit`: the result of `POST /code` without the output, always the last event unless the
+// execution failed
+// - `error`: `{"error": "..."}`, the execution failed or was cancelled
+//
+// The execution goes through the queue like `POST /code`, the stream starts once it's queued.
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request
+// - 401: Unauthorized
+// - 429: Too Many Requests, the user has too many queued executions
+// - 503: Service Unavailable, the execution queue is full
+func StreamCodeHandler(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	codeReq, errDecode := decodeCodeReq(request)
+	if errDecode != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	language, status := codeLanguage(*codeReq.Language)
+	if language == nil {
+		writer.WriteHeader(status)
+		return
+	}
+	project, errProject := codeReq.Project(*language)
+	if errProject != nil {
 		writer.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	if codeReq.Code == nil || codeReq.Language == nil {
+	stream := newEventStream(writer)
+	defer stream.close()
+	// The output is sent by the worker running the job, the handler only returns once it's finished
+	options := driver.ExecutionOptions{Stdin: codeReq.StdinReader(), Limits: executionLimits(email, *codeReq.Language), User: email}
+	job, errSubmit := driver.SubmitJob(email, func(ctx context.Context) (*driver.ExecutionResult, error) {
+		result, errExec := driver.HandleExecutionStream(ctx, *language, project, options, func(output driver.OutputStream, data []byte) {
+			stream.send(string(output), codeEvent{Data: string(data)})
+		})
+		if errExec != nil {
+			log.Error("[handlers.StreamCodeHandler] Error while executing the code", "error", errExec)
+		}
+		return result, errExec
+	})
+	if errSubmit != nil {
+		writer.WriteHeader(queueStatus(errSubmit))
+		return
+	}
+	stream.start()
+
+	job, status = waitJob(request, job, email)
+	switch {
+	case status == http.StatusConflict:
+		stream.send("error", codeEvent{Error: "execution cancelled"})
+	case status != http.StatusOK && job.Error != "":
+		stream.send("error", codeEvent{Error: job.Error})
+	case status != http.StatusOK:
+		stream.send("error", codeEvent{Error: http.StatusText(status)})
+	default:
+		stream.send("exit", job.Result)
+	}
+}
+
+// Server sent events of `StreamCodeHandler`. The worker running the job sends the output, which waits
+// until the handler sent the headers, and nothing is written once the handler returned.
+type eventStream struct {
+	writer     http.ResponseWriter
+	controller *http.ResponseController
+	ready      chan struct{} // Closed once the headers are sent
+	done       chan struct{} // Closed once the handler returned
+	mu         sync.Mutex
+}
+
+func newEventStream(writer http.ResponseWriter) *eventStream {
+	return &eventStream{
+		writer:     writer,
+		controller: http.NewResponseController(writer),
+		ready:      make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Send the headers of the stream, the events sent before are written once they're out
+func (s *eventStream) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The program can wait and run for longer than the write timeout of the server
+	s.controller.SetWriteDeadline(time.Time{})
+	s.writer.Header().Set("Content-Type", "text/event-stream")
+	s.writer.Header().Set("Cache-Control", "no-cache")
+	s.writer.WriteHeader(http.StatusOK)
+	s.controller.Flush()
+	close(s.ready)
+}
+
+func (s *eventStream) send(event string, payload any) {
+	jsonPayload, errJSON := json.Marshal(payload)
+	if errJSON != nil {
+		return
+	}
+	select {
+	case <-s.ready:
+	case <-s.done:
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	fmt.Fprintf(s.writer, "event: %s\ndata: %s\n\n", event, jsonPayload)
+	s.controller.Flush()
+}
+
+// Drop the events sent from now on, the response can't be written once the handler returned
+func (s *eventStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.done)
+}
+
+// Run code with its stdin attached to a web socket. The first frame sent by the client is the JSON
+// body of `POST /code`, then the console protocol is used: input and resize messages are forwarded
+// to the program, output and a final exit message are sent back. The `width` and `height` query
+// parameters set the initial size of the tty.
+//
+// The execution goes through the queue like `POST /code`, the program starts once a worker is free.
+// A full queue is reported with an error message and closing the socket cancels the execution, even
+// while it's queued.
+// Possible HTTP response codes:
+// - 101: Switching Protocols
+// - 400: Bad Request
+// - 401: Unauthorized
+func InteractiveCodeHandler(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	width, errW := strconv.Atoi(request.URL.Query().Get("width"))
+	height, errH := strconv.Atoi(request.URL.Query().Get("height"))
+	if errW != nil || errH != nil || width < 0 || height < 0 {
 		writer.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	output, errExec := driver.HandleExecution(ctx, &codeReq)
-	if errExec != nil {
-		log.Error("[handlers.PostCodeHandler] Error while executing the code: ", errExec)
-		writer.WriteHeader(http.StatusInternalServerError)
+	upgrader.CheckOrigin = func(r *http.Request) bool {
+		return true
+	}
+	wsConn, errUpgrade := upgrader.Upgrade(writer, request, nil)
+	if errUpgrade != nil {
+		log.Error("[handlers.InteractiveCodeHandler] Error while upgrading the connection", "error", errUpgrade)
+		return
+	}
+	defer wsConn.Close()
+	conn := driver.NewConsoleConn(wsConn)
+
+	var codeReq driver.CodeReq
+	if errJSON := wsConn.ReadJSON(&codeReq); errJSON != nil || codeReq.Language == nil {
+		conn.WriteError(errInvalidCodeReq)
+		return
+	}
+	language, _ := codeLanguage(*codeReq.Language)
+	if language == nil {
+		conn.WriteError(errUnsupportedLanguage)
+		return
+	}
+	project, errProject := codeReq.Project(*language)
+	if errProject != nil {
+		conn.WriteError(errProject)
 		return
 	}
-	if len(output) == 0 {
-		log.Warn("[handlers.PostCodeHandler] Empty output")
+
+	// A client that goes away while its job is queued or running cancels it
+	ctx, cancel := context.WithCancel(request.Context())
+	defer cancel()
+	conn.Keepalive(driver.DefaultPingInterval, ctx.Done())
+	conn.ReadAhead(cancel)
+
+	limits := executionLimits(email, *codeReq.Language)
+	job, errSubmit := driver.SubmitJob(email, func(ctx context.Context) (*driver.ExecutionResult, error) {
+		errExec := driver.RunInteractive(ctx, *language, project, codeReq.Stdin, limits, email, conn, uint(width), uint(height))
+		if errExec != nil {
+			log.Error("[handlers.InteractiveCodeHandler] Error while executing the code", "error", errExec)
+		}
+		return nil, errExec
+	})
+	if errSubmit != nil {
+		conn.WriteError(errSubmit)
+		return
+	}
+	if job, status := waitJob(request.WithContext(ctx), job, email); status != http.StatusOK && job.Error != "" {
+		conn.WriteError(errors.New(job.Error))
+	}
+}
+
+// Decode the code request from a JSON body or a multipart form with an optional `archive` file
+func decodeCodeReq(request *http.Request) (*driver.CodeReq, error) {
+	var codeReq driver.CodeReq
+	mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		if errJSON := json.NewDecoder(request.Body).Decode(&codeReq); errJSON != nil {
+			return nil, errJSON
+		}
+	} else {
+		request.Body = http.MaxBytesReader(nil, request.Body, driver.MaxProjectBytes+maxFormOverhead)
+		if errForm := request.ParseMultipartForm(maxFormMemory); errForm != nil {
+			return nil, errForm
+		}
+		for field, value := range map[string]**string{
+			"code":       &codeReq.Code,
+			"entrypoint": &codeReq.Entrypoint,
+			"language":   &codeReq.Language,
+			"manifest":   &codeReq.Manifest,
+			"stdin":      &codeReq.Stdin,
+		} {
+			if values, ok := request.MultipartForm.Value[field]; ok && len(values) > 0 {
+				*value = &values[0]
+			}
+		}
+		// Repeated fields, in order for the arguments and as `NAME=value` for the variables
+		codeReq.Args = request.MultipartForm.Value["arg"]
+		for _, variable := range request.MultipartForm.Value["env"] {
+			name, value, ok := strings.Cut(variable, "=")
+			if !ok {
+				return nil, errInvalidCodeReq
+			}
+			if codeReq.Env == nil {
+				codeReq.Env = map[string]string{}
+			}
+			codeReq.Env[name] = value
+		}
+		archive, header, errFile := request.FormFile("archive")
+		if errFile == nil {
+			defer archive.Close()
+			files, errArchive := driver.ReadArchive(header.Filename, archive)
+			if errArchive != nil {
+				return nil, errArchive
+			}
+			codeReq.Files = files
+		} else if !errors.Is(errFile, http.ErrMissingFile) {
+			return nil, errFile
+		}
+	}
+	if codeReq.Language == nil {
+		return nil, errInvalidCodeReq
+	}
+	return &codeReq, nil
+}
+
+const (
+	maxFormMemory   = 32 * 1024 * 1024
+	maxFormOverhead = 1024 * 1024 // Other fields of the form, and the archive overhead
+)
+
+// Get the execution limits of the user for the language, falling back to the defaults on error
+func executionLimits(email string, language string) driver.ExecutionLimits {
+	limits, err := database.GetExecutionLimits(email, language, driver.ExecutionDefaults())
+	if err != nil {
+		log.Error("[handlers.executionLimits] Error while getting the execution limits", "error", err)
 	}
-	writer.Write(output)
-	writer.WriteHeader(http.StatusOK)
+	return limits
 }
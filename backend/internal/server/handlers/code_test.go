@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/AlvaroParker/web-console/internal/driver"
+)
+
+func TestEventStreamIdleQueue(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	stream := newEventStream(recorder)
+	// An idle worker starts the job right away, its output must wait for the headers
+	job, errSubmit := driver.SubmitJob("stream@test", func(ctx context.Context) (*driver.ExecutionResult, error) {
+		stream.send("stdout", codeEvent{Data: "hello"})
+		return &driver.ExecutionResult{}, nil
+	})
+	if errSubmit != nil {
+		t.Fatalf("SubmitJob() = %v", errSubmit)
+	}
+	stream.start()
+	job, errWait := driver.WaitJob(context.Background(), job.ID, "stream@test")
+	if errWait != nil || job.Status != driver.JobDone {
+		t.Fatalf("WaitJob() = %v, %v", job.Status, errWait)
+	}
+	stream.send("exit", job.Result)
+	stream.close()
+	stream.send("stdout", codeEvent{Data: "after close"})
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	if got := recorder.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", got)
+	}
+	body := recorder.Body.String()
+	if !strings.HasPrefix(body, "event: stdout\ndata: {\"data\":\"hello\"}\n\n") {
+		t.Errorf("body = %q, the output isn't the first event", body)
+	}
+	if !strings.Contains(body, "event: exit\n") || strings.Contains(body, "after close") {
+		t.Errorf("body = %q, want the exit event and nothing after the close", body)
+	}
+}
+
+func TestEventStreamClosedBeforeStart(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	stream := newEventStream(recorder)
+	stream.close()
+	// Doesn't block once the handler returned without starting the stream
+	stream.send("stdout", codeEvent{Data: "hello"})
+	if recorder.Body.Len() != 0 {
+		t.Errorf("body = %q, want nothing", recorder.Body.String())
+	}
+}
@@ -2,22 +2,36 @@ package handlers
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
 
 	"github.com/AlvaroParker/web-console/internal/database"
+	"github.com/AlvaroParker/web-console/internal/driver"
 	"github.com/charmbracelet/log"
+	"github.com/docker/docker/errdefs"
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{}
+// The console protocol version is negotiated through the `Sec-WebSocket-Protocol` header, clients
+// that don't send it get the legacy base64 protocol
+var upgrader = websocket.Upgrader{
+	Subprotocols: driver.SupportedProtocols,
+}
 
 // Route: `/console/ws handler`
 //
 // This handler will upgrade a GET request to a web socket connection and attach
 // a container to it.
+//
+// With `mode=readonly` the client only gets the output of the container, input is never forwarded
+// and disconnecting doesn't stop the container. The container isn't started either, the answer is
+// 409 Conflict if it isn't running. `since` (timestamp or relative duration like `10m`)
+// and `tail` (number of lines) limit the output replayed on attach.
 func ConsoleHandler(writer http.ResponseWriter, request *http.Request) {
 	log.Debug("[handlers.ConsoleHandler] Request received")
 	email, errAuth := database.Middleware(request)
@@ -34,23 +48,77 @@ func ConsoleHandler(writer http.ResponseWriter, request *http.Request) {
 	height, errH := strconv.Atoi(rawHeight)
 	logs := request.URL.Query().Get("logs")
 	logsBool := logs == "true"
+	mode := request.URL.Query().Get("mode")
+	since := request.URL.Query().Get("since")
+	tail := request.URL.Query().Get("tail")
+	_, errTail := strconv.Atoi(tail)
 
 	if hash == "" || rawWidth == "" || rawHeight == "" || errW != nil || errH != nil {
 		writer.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	// Get the container owned by the given email with the given hash
-	wc, err := database.GetContainer(email, hash)
+	if (mode != "" && mode != "readonly") || (tail != "" && tail != "all" && errTail != nil) {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// Get the container owned by or shared with the given email with the given hash
+	wc, owner, role, err := database.GetContainerAccess(email, hash)
 	// Check if there was an error while creating the new WebContainer
+	if err == sql.ErrNoRows {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
 		log.Error("[handlers.ConsoleHandler] Error while creating the WebContainer", "error", err)
 		return
 	}
-	errorCreate := wc.Start(ctx)
-	if errorCreate != nil {
+	// Viewers only watch the container, they never start it
+	if mode != "readonly" {
+		errorCreate := wc.Start(ctx)
+		if errorCreate != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			log.Error("[handlers.ConsoleHandler] Error while starting the container: ", errorCreate)
+			return
+		}
+	}
+	// Attach to an exec session if requested, otherwise reattach to the main session of the container
+	// if there's one alive. The container is only stopped once no client has been attached for the
+	// detach timeout.
+	var session *driver.Session
+	var errSession error
+	if mode == "readonly" {
+		// Every read only client gets its own attach without input, it never stops the container
+		role = driver.RoleViewer
+		session, errSession = driver.Sessions.OpenReadOnly(ctx, owner, wc, driver.AttachConfig{
+			Logs:  logsBool,
+			Since: since,
+			Tail:  tail,
+		})
+	} else if sessionID := request.URL.Query().Get("session"); sessionID != "" {
+		session, errSession = ownedSession(owner, hash, sessionID)
+	} else {
+		session, errSession = driver.Sessions.Open(ctx, owner, wc, logsBool, consolePolicy(owner, wc))
+	}
+	if errSession != nil {
+		if errors.Is(errSession, driver.ErrSessionForbidden) {
+			writer.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if errors.Is(errSession, driver.ErrSessionNotFound) || errors.Is(errSession, driver.ErrContainerMismatch) {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if errors.Is(errSession, driver.ErrNotRunning) {
+			writer.WriteHeader(http.StatusConflict)
+			return
+		}
+		if errdefs.IsInvalidParameter(errSession) {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
 		writer.WriteHeader(http.StatusInternalServerError)
-		log.Error("[handlers.ConsoleHandler] Error while starting the container: ", errorCreate)
+		log.Error("[handlers.ConsoleHandler] Error while opening the console session", "error", errSession)
 		return
 	}
 
@@ -63,16 +131,28 @@ func ConsoleHandler(writer http.ResponseWriter, request *http.Request) {
 	wsConn, err := upgrader.Upgrade(writer, request, nil)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error while upgrading the connection: ", err)
+		return
 	}
+	defer wsConn.Close()
 
-	wsConn.SetCloseHandler(func(code int, text string) error {
-		go wc.Close(ctx)
-		fmt.Println("Connection to client closed with code ", code)
-		return nil
-	})
+	log.Debug("[handlers.ConsoleHandler] Connection upgraded, attaching session...", "protocol", wsConn.Subprotocol(), "session", session.ID)
+	session.Serve(wsConn, email, clientIP(request), role, width, height)
+}
 
-	defer wsConn.Close()
-	fmt.Println("Connection upgraded, attaching container...")
-	wc.AttachContainer(ctx, true, wsConn, logsBool, width, height)
-	defer wc.Close(ctx)
+// Get the session policy of the owner of the container, falling back to the defaults on error
+func consolePolicy(owner string, wc *driver.WebContainer) driver.SessionPolicy {
+	policy, err := database.GetConsolePolicy(owner, string(wc.Image), driver.Sessions.DefaultPolicy())
+	if err != nil {
+		log.Error("[handlers.consolePolicy] Error while getting the console policy", "error", err)
+	}
+	return policy
+}
+
+// Address of the client that sent the request, without the port
+func clientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
 }
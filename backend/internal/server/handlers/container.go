@@ -248,7 +248,7 @@ func HandleFullStop(writer http.ResponseWriter, request *http.Request) {
 func HandleResize(writer http.ResponseWriter, request *http.Request) {
 	log.Debug("[handlers.HandleResize] Request received")
 
-	_, errAuth := database.Middleware(request)
+	email, errAuth := database.Middleware(request)
 	if errAuth != nil {
 		writer.WriteHeader(http.StatusUnauthorized)
 		return
@@ -263,6 +263,20 @@ func HandleResize(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
+	// Exec sessions have their own tty
+	if sessionID := request.URL.Query().Get("session"); sessionID != "" {
+		session, errSession := ownedSession(email, id, sessionID)
+		if errSession != nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := session.Resize(uint(width), uint(height)); err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			log.Error("[handlers.HandleResize] Error while resizing the session: ", err)
+		}
+		return
+	}
+
 	if err := driver.ContainerResize(uint(height), uint(width), id); err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
 		log.Error("[handlers.HandleResize] Error while resizing the container: ", err)
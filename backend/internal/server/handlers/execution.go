@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AlvaroParker/web-console/internal/database"
+	"github.com/AlvaroParker/web-console/internal/driver"
+	"github.com/charmbracelet/log"
+)
+
+// List the past executions of the user, newest first, without their project and output. Query
+// parameters, all optional:
+// - language: exact match filter
+// - from, to: RFC 3339 timestamps, `to` is exclusive
+// - limit (max 500), offset: pagination
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request
+// - 401: Unauthorized
+// - 500: Internal Server Error
+func ListExecutions(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	filter, errFilter := executionFilter(request, email)
+	if errFilter != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	executions, errDB := database.QueryExecutions(filter)
+	if errDB != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.ListExecutions] Error while querying the executions", "error", errDB)
+		return
+	}
+	jsonExecutions, errJSON := json.Marshal(executions)
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.ListExecutions] Error while marshalling the executions", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonExecutions)
+}
+
+// Get a past execution of the user with its files, stdin, arguments, variables and result
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 404: Not Found
+// - 500: Internal Server Error
+func GetExecution(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	execution, status := userExecution(request, email)
+	if execution == nil {
+		writer.WriteHeader(status)
+		return
+	}
+	jsonExecution, errJSON := json.Marshal(execution)
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.GetExecution] Error while marshalling the execution", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonExecution)
+}
+
+// Run a past execution again with the same files, stdin, arguments and variables. The answer is the
+// same as `POST /code` and the new execution is stored in the history.
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request, the language is no longer available
+// - 401: Unauthorized
+// - 404: Not Found
+// - 409: Conflict, the execution was cancelled
+// - 429: Too Many Requests, the user has too many queued executions
+// - 500: Internal Server Error
+// - 503: Service Unavailable, the execution queue is full
+func RerunExecution(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	execution, status := userExecution(request, email)
+	if execution == nil {
+		writer.WriteHeader(status)
+		return
+	}
+	language, status := codeLanguage(execution.Language)
+	if language == nil {
+		writer.WriteHeader(status)
+		return
+	}
+	project := driver.Project{Files: execution.Files, Entrypoint: execution.Entrypoint, Args: execution.Args, Env: execution.Env}
+	runCode(writer, request, email, *language, project, execution.Stdin)
+}
+
+// Delete a past execution of the user
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 404: Not Found
+// - 500: Internal Server Error
+func DeleteExecution(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	id, errID := strconv.ParseInt(request.PathValue("id"), 10, 64)
+	if errID != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	deleted, errDB := database.DeleteExecution(id, email)
+	if errDB != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.DeleteExecution] Error while deleting the execution", "error", errDB)
+		return
+	}
+	if !deleted {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+// Get the execution of the `id` path parameter, returns the HTTP status to answer if there's none
+func userExecution(request *http.Request, email string) (*database.Execution, int) {
+	id, errID := strconv.ParseInt(request.PathValue("id"), 10, 64)
+	if errID != nil {
+		return nil, http.StatusNotFound
+	}
+	execution, errDB := database.GetExecution(id, email)
+	if errors.Is(errDB, sql.ErrNoRows) {
+		return nil, http.StatusNotFound
+	}
+	if errDB != nil {
+		log.Error("[handlers.userExecution] Error while getting the execution", "error", errDB)
+		return nil, http.StatusInternalServerError
+	}
+	return execution, http.StatusOK
+}
+
+func executionFilter(request *http.Request, email string) (database.ExecutionFilter, error) {
+	query := request.URL.Query()
+	filter := database.ExecutionFilter{
+		Email:    email,
+		Language: query.Get("language"),
+	}
+	var err error
+	if raw := query.Get("from"); raw != "" {
+		if filter.From, err = time.Parse(time.RFC3339, raw); err != nil {
+			return filter, err
+		}
+	}
+	if raw := query.Get("to"); raw != "" {
+		if filter.To, err = time.Parse(time.RFC3339, raw); err != nil {
+			return filter, err
+		}
+	}
+	if raw := query.Get("limit"); raw != "" {
+		if filter.Limit, err = strconv.Atoi(raw); err != nil {
+			return filter, err
+		}
+	}
+	if raw := query.Get("offset"); raw != "" {
+		if filter.Offset, err = strconv.Atoi(raw); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/AlvaroParker/web-console/internal/database"
+	"github.com/AlvaroParker/web-console/internal/driver"
+	"github.com/charmbracelet/log"
+)
+
+// Queue an execution and answer right away with the job, the body is the same as `POST /code`. The
+// `Location` header is the URL of the job, poll it until its status is `done`, `failed` or
+// `cancelled`. Finished jobs are kept for a while, see `QUEUE_JOB_RETENTION`.
+// Possible HTTP response codes:
+// - 202: Accepted
+// - 400: Bad Request, also for unsupported languages
+// - 401: Unauthorized
+// - 429: Too Many Requests, the user has too many queued executions
+// - 500: Internal Server Error
+// - 503: Service Unavailable, the execution queue is full
+func SubmitJob(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	codeReq, errDecode := decodeCodeReq(request)
+	if errDecode != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	language, status := codeLanguage(*codeReq.Language)
+	if language == nil {
+		writer.WriteHeader(status)
+		return
+	}
+	project, errProject := codeReq.Project(*language)
+	if errProject != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	job, errSubmit := driver.SubmitJob(email, executeCode(email, *language, project, codeReq.Stdin, nil))
+	if errSubmit != nil {
+		writer.WriteHeader(queueStatus(errSubmit))
+		return
+	}
+	jsonJob, errJSON := json.Marshal(job)
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.SubmitJob] Error while marshalling the job", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Location", "/code/jobs/"+job.ID)
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusAccepted)
+	writer.Write(jsonJob)
+}
+
+// Get a job of the user: its status, its position among the queued jobs of the user and, once it's
+// done, the result of the execution.
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 404: Not Found
+// - 500: Internal Server Error
+func GetJob(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	job, errJob := driver.GetJob(request.PathValue("id"), email)
+	if errJob != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	jsonJob, errJSON := json.Marshal(job)
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.GetJob] Error while marshalling the job", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonJob)
+}
+
+// Get the result of a job of the user, same as the answer of `POST /code`
+// Possible HTTP response codes:
+// - 200: OK
+// - 202: Accepted, the job is queued or running
+// - 401: Unauthorized
+// - 404: Not Found
+// - 410: Gone, the job failed or was cancelled
+// - 500: Internal Server Error
+func GetJobResult(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	job, errJob := driver.GetJob(request.PathValue("id"), email)
+	if errJob != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch job.Status {
+	case driver.JobQueued, driver.JobRunning:
+		writer.WriteHeader(http.StatusAccepted)
+		return
+	case driver.JobFailed, driver.JobCancelled:
+		writer.WriteHeader(http.StatusGone)
+		return
+	}
+	jsonResult, errJSON := json.Marshal(job.Result)
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.GetJobResult] Error while marshalling the result", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonResult)
+}
+
+// Cancel a queued or running job of the user, a running execution is stopped
+// Possible HTTP response codes:
+// - 204: No Content
+// - 401: Unauthorized
+// - 404: Not Found
+// - 409: Conflict, the job already finished
+func CancelJob(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	_, errCancel := driver.CancelJob(request.PathValue("id"), email)
+	switch {
+	case errors.Is(errCancel, driver.ErrJobNotFound):
+		writer.WriteHeader(http.StatusNotFound)
+	case errors.Is(errCancel, driver.ErrJobFinished):
+		writer.WriteHeader(http.StatusConflict)
+	default:
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Get the metrics of the execution queue: queued and running jobs, users waiting, submitted and
+// rejected jobs, finished jobs by status, and the average and max time jobs waited before running
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 403: Forbidden
+// - 500: Internal Server Error
+func GetQueueStats(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.GetQueueStats] Request received")
+	if status := authAdmin(request); status != http.StatusOK {
+		writer.WriteHeader(status)
+		return
+	}
+	jsonStats, errJSON := json.Marshal(driver.GetQueueStats())
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.GetQueueStats] Error while marshalling the queue metrics", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonStats)
+}
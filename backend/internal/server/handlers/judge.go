@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/AlvaroParker/web-console/internal/database"
+	"github.com/AlvaroParker/web-console/internal/driver"
+	"github.com/charmbracelet/log"
+)
+
+// Run a submission against test cases. The body is the JSON body of `POST /code` with:
+// - tests: list of `{"name", "stdin", "expected_stdout", "time_limit_ms"}`, only `stdin` and
+// `expected_stdout` are required
+// - checker: `exact` (default), `whitespace` or `float`
+// - tolerance: absolute or relative error allowed by the `float` checker
+//
+// The project is built once, then each test runs in a new container with the limits of the user. The
+// answer has the verdict of each test (`accepted`, `wrong_answer`, `runtime_error`,
+// `time_limit_exceeded`, `memory_limit_exceeded` or `output_limit_exceeded`) and the first verdict
+// that isn't `accepted`, which is `compilation_error` if the build failed.
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request
+// - 401: Unauthorized
+// - 409: Conflict, the submission was cancelled
+// - 429: Too Many Requests, the user has too many queued executions
+// - 500: Internal Server Error
+// - 503: Service Unavailable, the execution queue is full
+func JudgeCodeHandler(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var judgeReq driver.JudgeReq
+	jsonErr := json.NewDecoder(request.Body).Decode(&judgeReq)
+	if jsonErr != nil || judgeReq.Language == nil || judgeReq.Validate() != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	language, status := codeLanguage(*judgeReq.Language)
+	if language == nil {
+		writer.WriteHeader(status)
+		return
+	}
+	project, errProject := judgeReq.Project(*language)
+	if errProject != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// The tests run one after the other, which can take longer than the write timeout of the server
+	http.NewResponseController(writer).SetWriteDeadline(time.Time{})
+	// The whole submission is a single job of the queue, the judgement is set by the worker
+	var judgement *driver.JudgeResult
+	limits := executionLimits(email, *judgeReq.Language)
+	job, errSubmit := driver.SubmitJob(email, func(ctx context.Context) (*driver.ExecutionResult, error) {
+		var errJudge error
+		judgement, errJudge = driver.Judge(ctx, *language, project, &judgeReq, limits)
+		if errJudge != nil {
+			log.Error("[handlers.JudgeCodeHandler] Error while judging the submission", "error", errJudge)
+		}
+		return nil, errJudge
+	})
+	if errSubmit != nil {
+		writer.WriteHeader(queueStatus(errSubmit))
+		return
+	}
+	if _, status := waitJob(request, job, email); status != http.StatusOK {
+		writer.WriteHeader(status)
+		return
+	}
+	jsonJudgement, errJSON := json.Marshal(judgement)
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.JudgeCodeHandler] Error while marshalling the judgement", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonJudgement)
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/AlvaroParker/web-console/internal/database"
+	"github.com/AlvaroParker/web-console/internal/driver"
+	"github.com/charmbracelet/log"
+	"github.com/lib/pq"
+)
+
+// List the languages available in the code editor
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 500: Internal Server Error
+func GetLanguages(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.GetLanguages] Request received")
+	if _, errAuth := database.Middleware(request); errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	languages, errDB := database.GetEnabledLanguages()
+	if errDB != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.GetLanguages] Error while getting the languages", "error", errDB)
+		return
+	}
+	jsonLanguages, errJSON := json.Marshal(languages)
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.GetLanguages] Error while marshalling the languages", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonLanguages)
+}
+
+// List every registered language with its configuration
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 403: Forbidden
+// - 500: Internal Server Error
+func ListLanguages(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.ListLanguages] Request received")
+	if status := authAdmin(request); status != http.StatusOK {
+		writer.WriteHeader(status)
+		return
+	}
+	languages, errDB := database.GetLanguages()
+	if errDB != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.ListLanguages] Error while getting the languages", "error", errDB)
+		return
+	}
+	jsonLanguages, errJSON := json.Marshal(languages)
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.ListLanguages] Error while marshalling the languages", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonLanguages)
+}
+
+// Register a new language. Its image is pulled on the first execution if it isn't available. The
+// language is enabled unless the body sets `enabled` to false.
+// Possible HTTP response codes:
+// - 201: Created
+// - 400: Bad Request
+// - 401: Unauthorized
+// - 403: Forbidden
+// - 409: Conflict, a language with the same name exists
+// - 500: Internal Server Error
+func AddLanguage(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.AddLanguage] Request received")
+	if status := authAdmin(request); status != http.StatusOK {
+		writer.WriteHeader(status)
+		return
+	}
+	// A field missing from the body keeps its value
+	language := driver.Language{Enabled: true}
+	if errJSON := json.NewDecoder(request.Body).Decode(&language); errJSON != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if errValid := database.ValidateLanguage(&language); errValid != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	errDB := database.AddLanguage(&language)
+	var errPQ *pq.Error
+	if errors.As(errDB, &errPQ) && errPQ.Code == "23505" {
+		writer.WriteHeader(http.StatusConflict)
+		return
+	}
+	if errDB != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.AddLanguage] Error while adding the language", "error", errDB)
+		return
+	}
+	writer.WriteHeader(http.StatusCreated)
+}
+
+// Replace the configuration of a language, the name in the body is ignored. Like for a new language,
+// a body without `enabled` enables it.
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request
+// - 401: Unauthorized
+// - 403: Forbidden
+// - 404: Not Found
+// - 500: Internal Server Error
+func UpdateLanguage(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.UpdateLanguage] Request received")
+	if status := authAdmin(request); status != http.StatusOK {
+		writer.WriteHeader(status)
+		return
+	}
+	// A field missing from the body keeps its value
+	language := driver.Language{Enabled: true}
+	if errJSON := json.NewDecoder(request.Body).Decode(&language); errJSON != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	language.Name = request.PathValue("name")
+	if errValid := database.ValidateLanguage(&language); errValid != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	updated, errDB := database.UpdateLanguage(&language)
+	if errDB != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.UpdateLanguage] Error while updating the language", "error", errDB)
+		return
+	}
+	if !updated {
+		writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 403: Forbidden
+// - 404: Not Found
+// - 500: Internal Server Error
+func DeleteLanguage(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.DeleteLanguage] Request received")
+	if status := authAdmin(request); status != http.StatusOK {
+		writer.WriteHeader(status)
+		return
+	}
+	deleted, errDB := database.DeleteLanguage(request.PathValue("name"))
+	if errDB != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.DeleteLanguage] Error while deleting the language", "error", errDB)
+		return
+	}
+	if !deleted {
+		writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// Get an enabled language for a code request, returns the HTTP status to answer otherwise
+func codeLanguage(name string) (*driver.Language, int) {
+	language, errDB := database.GetLanguage(name)
+	if errDB == sql.ErrNoRows || (errDB == nil && !language.Enabled) {
+		return nil, http.StatusBadRequest
+	}
+	if errDB != nil {
+		log.Error("[handlers.codeLanguage] Error while getting the language", "error", errDB)
+		return nil, http.StatusInternalServerError
+	}
+	return language, http.StatusOK
+}
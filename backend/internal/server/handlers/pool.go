@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/AlvaroParker/web-console/internal/driver"
+	"github.com/charmbracelet/log"
+)
+
+// Get the metrics of the pool of code containers: hits and misses of the executions and their hit
+// rate, containers created, discarded and failed, and idle containers per language
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 403: Forbidden
+// - 500: Internal Server Error
+func GetPoolStats(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.GetPoolStats] Request received")
+	if status := authAdmin(request); status != http.StatusOK {
+		writer.WriteHeader(status)
+		return
+	}
+	jsonStats, errJSON := json.Marshal(driver.GetPoolStats())
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.GetPoolStats] Error while marshalling the pool metrics", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonStats)
+}
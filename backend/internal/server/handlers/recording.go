@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AlvaroParker/web-console/internal/database"
+	"github.com/AlvaroParker/web-console/internal/driver"
+	"github.com/charmbracelet/log"
+)
+
+// Enable or disable the recording of the console sessions of a container
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request
+// - 401: Unauthorized
+// - 404: Not Found
+// - 500: Internal Server Error
+func SetRecording(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.SetRecording] Request received")
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var recordReq database.RecordReq
+	if errJSON := json.NewDecoder(request.Body).Decode(&recordReq); errJSON != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	success, errDB := database.SetRecordContainer(request.PathValue("containerID"), email, recordReq.Record)
+	if errDB != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.SetRecording] Error while updating the container", "error", errDB)
+		return
+	}
+	if !success {
+		writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// List the recordings of a container, the container can be owned or shared
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 404: Not Found
+// - 500: Internal Server Error
+func ListRecordings(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.ListRecordings] Request received")
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	containerID := request.PathValue("containerID")
+	if _, _, _, err := database.GetContainerAccess(email, containerID); err != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	recordings, errList := driver.ListRecordings(containerID)
+	if errList != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.ListRecordings] Error while listing the recordings", "error", errList)
+		return
+	}
+	jsonRecordings, errJSON := json.Marshal(recordings)
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.ListRecordings] Error while marshalling the recordings", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonRecordings)
+}
+
+// Download a recording as an asciicast v2 file
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 404: Not Found
+func DownloadRecording(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.DownloadRecording] Request received")
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	containerID := request.PathValue("containerID")
+	if _, _, _, err := database.GetContainerAccess(email, containerID); err != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	recordingID := request.PathValue("recordingID")
+	file, errOpen := driver.OpenRecording(containerID, recordingID)
+	if errOpen != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+	writer.Header().Add("Content-Type", "application/x-asciicast")
+	writer.Header().Add("Content-Disposition", `attachment; filename="`+recordingID+`.cast"`)
+	io.Copy(writer, file)
+}
+
+// Delete a recording, only the owner of the container can do it
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 404: Not Found
+// - 500: Internal Server Error
+func DeleteRecording(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.DeleteRecording] Request received")
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	containerID := request.PathValue("containerID")
+	if _, err := database.GetContainerInfo(containerID, email); err != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := driver.DeleteRecording(containerID, request.PathValue("recordingID")); err != nil {
+		if errors.Is(err, driver.ErrRecordingNotFound) {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.DeleteRecording] Error while deleting the recording", "error", err)
+	}
+}
+
+// Upgrade to a web socket and stream the recording with the console protocol. The optional `speed`
+// query parameter scales the playback speed and `idle_limit` (seconds) caps the pauses.
+func ReplayRecording(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.ReplayRecording] Request received")
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	containerID := request.PathValue("containerID")
+	if _, _, _, err := database.GetContainerAccess(email, containerID); err != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	recordingID := request.PathValue("recordingID")
+	file, errOpen := driver.OpenRecording(containerID, recordingID)
+	if errOpen != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	file.Close()
+
+	speed := 1.0
+	if rawSpeed := request.URL.Query().Get("speed"); rawSpeed != "" {
+		parsed, errSpeed := strconv.ParseFloat(rawSpeed, 64)
+		if errSpeed != nil || parsed <= 0 || parsed > 64 {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		speed = parsed
+	}
+	idleLimit := driver.DefaultReplayIdleLimit
+	if rawIdle := request.URL.Query().Get("idle_limit"); rawIdle != "" {
+		parsed, errIdle := strconv.ParseFloat(rawIdle, 64)
+		if errIdle != nil || parsed <= 0 {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		idleLimit = time.Duration(parsed * float64(time.Second))
+	}
+
+	upgrader.CheckOrigin = func(r *http.Request) bool {
+		return true
+	}
+	wsConn, err := upgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		log.Error("[handlers.ReplayRecording] Error while upgrading the connection", "error", err)
+		return
+	}
+	defer wsConn.Close()
+	conn := driver.NewConsoleConn(wsConn)
+
+	// Stop the replay once the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for {
+			if _, errRead := conn.ReadMessage(); errRead != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	if errReplay := driver.ReplayRecording(ctx, containerID, recordingID, conn, speed, idleLimit); errReplay != nil && !errors.Is(errReplay, context.Canceled) {
+		conn.WriteError(errReplay)
+		log.Warn("[handlers.ReplayRecording] Replay ended with an error", "error", errReplay)
+	}
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/AlvaroParker/web-console/internal/database"
+	"github.com/AlvaroParker/web-console/internal/driver"
+	"github.com/charmbracelet/log"
+)
+
+// Open a new shell on a running container with docker exec
+// Possible HTTP response codes:
+// - 201: Created
+// - 400: Bad Request
+// - 401: Unauthorized
+// - 403: Forbidden
+// - 404: Not Found
+// - 429: Too Many Requests
+// - 500: Internal Server Error
+func NewSession(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.NewSession] Request received")
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	ctx := context.Background()
+	containerID := request.PathValue("containerID")
+
+	var sessionReq driver.SessionReq
+	if errJSON := json.NewDecoder(request.Body).Decode(&sessionReq); errJSON != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if sessionReq.Width == 0 || sessionReq.Height == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	wc, err := database.GetContainer(email, containerID)
+	if err != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	command := wc.Command
+	if sessionReq.Command != nil {
+		command = *sessionReq.Command
+	}
+	if !isCommandAllowed(string(wc.Image), command) {
+		log.Warn("[handlers.NewSession] Command not allowed", "command", command)
+		writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if errStart := wc.Start(ctx); errStart != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.NewSession] Error while starting the container", "error", errStart)
+		return
+	}
+	session, errSession := driver.Sessions.OpenExec(ctx, email, wc, strings.Fields(command), sessionReq.Width, sessionReq.Height, consolePolicy(email, wc))
+	if errSession != nil {
+		if errors.Is(errSession, driver.ErrTooManySessions) {
+			writer.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.NewSession] Error while opening the exec session", "error", errSession)
+		return
+	}
+
+	jsonSession, errJSON := json.Marshal(session.Info())
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.NewSession] Error while marshalling the session", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	writer.Write(jsonSession)
+}
+
+// List the console sessions running on a container, the container can be owned or shared
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 404: Not Found
+// - 500: Internal Server Error
+func ListSessions(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.ListSessions] Request received")
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	containerID := request.PathValue("containerID")
+	if _, _, _, err := database.GetContainerAccess(email, containerID); err != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	jsonSessions, errJSON := json.Marshal(driver.Sessions.List(containerID))
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.ListSessions] Error while marshalling the sessions", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonSessions)
+}
+
+// Stop the process of a console session. Closing the main session of a container stops the container.
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 404: Not Found
+// - 500: Internal Server Error
+func CloseSession(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.CloseSession] Request received")
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	session, errSession := ownedSession(email, request.PathValue("containerID"), request.PathValue("sessionID"))
+	if errSession != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := session.Stop(context.Background()); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.CloseSession] Error while stopping the session", "error", err)
+		return
+	}
+}
+
+// Get a running session of the given container owned by `email`
+func ownedSession(email string, containerID string, sessionID string) (*driver.Session, error) {
+	session, ok := driver.Sessions.Get(sessionID)
+	if !ok {
+		return nil, driver.ErrSessionNotFound
+	}
+	if session.Owner != email {
+		return nil, driver.ErrSessionForbidden
+	}
+	if session.ContainerID != containerID {
+		return nil, driver.ErrContainerMismatch
+	}
+	return session, nil
+}
+
+// Check if the command is one of the valid commands of the image
+func isCommandAllowed(imageTag string, command string) bool {
+	validImages, errDB := database.GetValidImages()
+	if errDB != nil {
+		log.Error("[handlers.isCommandAllowed] Error while getting valid images: ", errDB)
+		return false
+	}
+	for _, image := range validImages {
+		if image.ImageTag == imageTag {
+			return slices.Contains(image.Commands, command)
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/AlvaroParker/web-console/internal/database"
+	"github.com/AlvaroParker/web-console/internal/driver"
+	"github.com/charmbracelet/log"
+)
+
+// Share the console of a container with another user, or change the role of an existing share.
+// Connected participants get the new role immediately.
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request
+// - 401: Unauthorized
+// - 404: Not Found
+// - 500: Internal Server Error
+func ShareContainer(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.ShareContainer] Request received")
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	containerID := request.PathValue("containerID")
+	if _, err := database.GetContainerInfo(containerID, email); err != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var share database.Share
+	if errJSON := json.NewDecoder(request.Body).Decode(&share); errJSON != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if share.Email == "" || share.Email == email || !share.Role.IsGrantable() {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if _, errUser := database.SearchUser(share.Email); errUser != nil {
+		if errUser == sql.ErrNoRows {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writer.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := database.AddShare(containerID, share); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.ShareContainer] Error while sharing the container", "error", err)
+		return
+	}
+	driver.Sessions.SetRole(containerID, share.Email, share.Role)
+}
+
+// List the users a container is shared with
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 404: Not Found
+// - 500: Internal Server Error
+func ListShares(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.ListShares] Request received")
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	containerID := request.PathValue("containerID")
+	if _, err := database.GetContainerInfo(containerID, email); err != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	shares, errDB := database.GetShares(containerID)
+	if errDB != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.ListShares] Error while querying the database", "error", errDB)
+		return
+	}
+	jsonShares, errJSON := json.Marshal(shares)
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.ListShares] Error while marshalling the shares", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonShares)
+}
+
+// Stop sharing a container with a user, its clients are disconnected right away
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 404: Not Found
+// - 500: Internal Server Error
+func RevokeShare(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.RevokeShare] Request received")
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	containerID := request.PathValue("containerID")
+	if _, err := database.GetContainerInfo(containerID, email); err != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	target := request.PathValue("email")
+	success, errDB := database.DeleteShare(containerID, target)
+	if errDB != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.RevokeShare] Error while deleting the share", "error", errDB)
+		return
+	}
+	driver.Sessions.Revoke(containerID, target)
+	if !success {
+		writer.WriteHeader(http.StatusNotFound)
+	}
+}
@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/AlvaroParker/web-console/internal/database"
+	"github.com/AlvaroParker/web-console/internal/driver"
+	"github.com/charmbracelet/log"
+)
+
+// Body of `POST /snippets` and `PUT /snippets/{id}`, the code is the same as `POST /code`
+type snippetReq struct {
+	driver.CodeReq
+	Name       string              `json:"name"`
+	Visibility database.Visibility `json:"visibility"` // private, link or public, defaults to private
+}
+
+// Save code as a new snippet of the user. The body is the same JSON as `POST /code` with a `name`
+// and a `visibility`: `private` snippets are only visible to their owner, `link` ones to anyone with
+// their id and `public` ones are also listed in `GET /snippets/public`. The `Location` header is the
+// permalink of the snippet.
+// Possible HTTP response codes:
+// - 201: Created
+// - 400: Bad Request, also for unsupported languages
+// - 401: Unauthorized
+// - 500: Internal Server Error
+func CreateSnippet(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	snippet, status := decodeSnippet(request, email)
+	if snippet == nil {
+		writer.WriteHeader(status)
+		return
+	}
+	if errDB := database.AddSnippet(snippet); errDB != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.CreateSnippet] Error while adding the snippet", "error", errDB)
+		return
+	}
+	writeSnippet(writer, snippet, http.StatusCreated)
+}
+
+// List the snippets of the user, most recently updated first, without their files. Query
+// parameters, all optional:
+// - language: exact match filter
+// - limit (max 500), offset: pagination
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request
+// - 401: Unauthorized
+// - 500: Internal Server Error
+func ListSnippets(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	filter, errFilter := snippetFilter(request)
+	if errFilter != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	filter.Email = email
+	listSnippets(writer, filter, email)
+}
+
+// List the public snippets of every user, with the same query parameters as `GET /snippets`. The
+// email of the owner is only set on the snippets of the user.
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request
+// - 401: Unauthorized
+// - 500: Internal Server Error
+func ListPublicSnippets(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	filter, errFilter := snippetFilter(request)
+	if errFilter != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	filter.Visibility = database.VisibilityPublic
+	listSnippets(writer, filter, email)
+}
+
+// Get a snippet with its files, stdin, arguments and variables. Private snippets of other users are
+// not found and the email of the owner is only set for the owner.
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 404: Not Found
+// - 500: Internal Server Error
+func GetSnippet(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	snippet, status := viewableSnippet(request, email)
+	if snippet == nil {
+		writer.WriteHeader(status)
+		return
+	}
+	snippet.HideOwner(email)
+	writeSnippet(writer, snippet, http.StatusOK)
+}
+
+// Replace the name, code and visibility of a snippet of the user, the body is the same as
+// `POST /snippets`. The id of the snippet doesn't change.
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request, also for unsupported languages
+// - 401: Unauthorized
+// - 404: Not Found
+// - 500: Internal Server Error
+func UpdateSnippet(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	snippet, status := decodeSnippet(request, email)
+	if snippet == nil {
+		writer.WriteHeader(status)
+		return
+	}
+	snippet.ID = request.PathValue("id")
+	updated, errDB := database.UpdateSnippet(snippet)
+	if errDB != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.UpdateSnippet] Error while updating the snippet", "error", errDB)
+		return
+	}
+	if !updated {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeSnippet(writer, snippet, http.StatusOK)
+}
+
+// Delete a snippet of the user, its forks are kept
+// Possible HTTP response codes:
+// - 200: OK
+// - 401: Unauthorized
+// - 404: Not Found
+// - 500: Internal Server Error
+func DeleteSnippet(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	deleted, errDB := database.DeleteSnippet(request.PathValue("id"), email)
+	if errDB != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.DeleteSnippet] Error while deleting the snippet", "error", errDB)
+		return
+	}
+	if !deleted {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+// Copy a snippet the user can view to a new private snippet of the user, which keeps the id of the
+// original in `forked_from`.
+// Possible HTTP response codes:
+// - 201: Created
+// - 401: Unauthorized
+// - 404: Not Found
+// - 500: Internal Server Error
+func ForkSnippet(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	original, status := viewableSnippet(request, email)
+	if original == nil {
+		writer.WriteHeader(status)
+		return
+	}
+	fork := *original
+	fork.Email = email
+	fork.Visibility = database.VisibilityPrivate
+	fork.ForkedFrom = &original.ID
+	if errDB := database.AddSnippet(&fork); errDB != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.ForkSnippet] Error while adding the fork", "error", errDB)
+		return
+	}
+	writeSnippet(writer, &fork, http.StatusCreated)
+}
+
+// Run a snippet the user can view with its files, stdin, arguments and variables. The answer is the
+// same as `POST /code` and the execution is stored in the history of the user.
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request, the language is no longer available
+// - 401: Unauthorized
+// - 404: Not Found
+// - 409: Conflict, the execution was cancelled
+// - 429: Too Many Requests, the user has too many queued executions
+// - 500: Internal Server Error
+// - 503: Service Unavailable, the execution queue is full
+func RunSnippet(writer http.ResponseWriter, request *http.Request) {
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	snippet, status := viewableSnippet(request, email)
+	if snippet == nil {
+		writer.WriteHeader(status)
+		return
+	}
+	language, status := codeLanguage(snippet.Language)
+	if language == nil {
+		writer.WriteHeader(status)
+		return
+	}
+	project := driver.Project{Files: snippet.Files, Entrypoint: snippet.Entrypoint, Args: snippet.Args, Env: snippet.Env}
+	runCode(writer, request, email, *language, project, snippet.Stdin)
+}
+
+// Snippet of the user in the body, returns the HTTP status to answer if it's invalid
+func decodeSnippet(request *http.Request, email string) (*database.Snippet, int) {
+	var snippetReq snippetReq
+	if errJSON := json.NewDecoder(request.Body).Decode(&snippetReq); errJSON != nil || snippetReq.Language == nil {
+		return nil, http.StatusBadRequest
+	}
+	language, status := codeLanguage(*snippetReq.Language)
+	if language == nil {
+		return nil, status
+	}
+	project, errProject := snippetReq.Project(*language)
+	if errProject != nil {
+		return nil, http.StatusBadRequest
+	}
+	snippet := &database.Snippet{
+		Email:      email,
+		Name:       snippetReq.Name,
+		Language:   language.Name,
+		Entrypoint: project.Entrypoint,
+		Visibility: snippetReq.Visibility,
+		Files:      project.Files,
+		Stdin:      snippetReq.Stdin,
+		Args:       project.Args,
+		Env:        project.Env,
+	}
+	if errValid := database.ValidateSnippet(snippet); errValid != nil {
+		return nil, http.StatusBadRequest
+	}
+	return snippet, http.StatusOK
+}
+
+// Get the snippet of the `id` path parameter if the user can view it, returns the HTTP status to
+// answer otherwise
+func viewableSnippet(request *http.Request, email string) (*database.Snippet, int) {
+	snippet, errDB := database.GetSnippet(request.PathValue("id"))
+	if errors.Is(errDB, sql.ErrNoRows) || (errDB == nil && !snippet.CanView(email)) {
+		return nil, http.StatusNotFound
+	}
+	if errDB != nil {
+		log.Error("[handlers.viewableSnippet] Error while getting the snippet", "error", errDB)
+		return nil, http.StatusInternalServerError
+	}
+	return snippet, http.StatusOK
+}
+
+// List the snippets matching the filter for the user, the emails of the other owners are hidden
+func listSnippets(writer http.ResponseWriter, filter database.SnippetFilter, email string) {
+	snippets, errDB := database.QuerySnippets(filter)
+	if errDB != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.listSnippets] Error while querying the snippets", "error", errDB)
+		return
+	}
+	for i := range snippets {
+		snippets[i].HideOwner(email)
+	}
+	jsonSnippets, errJSON := json.Marshal(snippets)
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.listSnippets] Error while marshalling the snippets", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonSnippets)
+}
+
+func writeSnippet(writer http.ResponseWriter, snippet *database.Snippet, status int) {
+	jsonSnippet, errJSON := json.Marshal(snippet)
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.writeSnippet] Error while marshalling the snippet", "error", errJSON)
+		return
+	}
+	if status == http.StatusCreated {
+		writer.Header().Add("Location", "/snippets/"+snippet.ID)
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	writer.Write(jsonSnippet)
+}
+
+func snippetFilter(request *http.Request) (database.SnippetFilter, error) {
+	query := request.URL.Query()
+	filter := database.SnippetFilter{Language: query.Get("language")}
+	var err error
+	if raw := query.Get("limit"); raw != "" {
+		if filter.Limit, err = strconv.Atoi(raw); err != nil {
+			return filter, err
+		}
+	}
+	if raw := query.Get("offset"); raw != "" {
+		if filter.Offset, err = strconv.Atoi(raw); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
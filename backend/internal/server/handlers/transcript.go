@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/AlvaroParker/web-console/internal/database"
+	"github.com/AlvaroParker/web-console/internal/driver"
+	"github.com/charmbracelet/log"
+)
+
+// Search the output of a console session. Query parameters:
+// - q: text to search (required)
+// - regex: `true` to interpret `q` as a regular expression
+// - ignore_case: `true` for a case insensitive search
+// - context: lines of context around each match
+//
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request
+// - 401: Unauthorized
+// - 404: Not Found
+// - 500: Internal Server Error
+func SearchTranscript(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.SearchTranscript] Request received")
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	session, errSession := accessibleSession(email, request)
+	if errSession != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	query := driver.TranscriptQuery{
+		Pattern:    request.URL.Query().Get("q"),
+		Regex:      request.URL.Query().Get("regex") == "true",
+		IgnoreCase: request.URL.Query().Get("ignore_case") == "true",
+	}
+	if query.Pattern == "" {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if rawContext := request.URL.Query().Get("context"); rawContext != "" {
+		contextLines, errContext := strconv.Atoi(rawContext)
+		if errContext != nil || contextLines < 0 {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		query.Context = contextLines
+	}
+
+	matches, errSearch := session.Transcript().Search(query)
+	if errSearch != nil {
+		// Only an invalid regular expression can fail
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	jsonMatches, errJSON := json.Marshal(matches)
+	if errJSON != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		log.Error("[handlers.SearchTranscript] Error while marshalling the matches", "error", errJSON)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	writer.Write(jsonMatches)
+}
+
+// Export the output of a console session, the `format` query parameter is either `text` (default)
+// or `html`, which keeps the colors
+// Possible HTTP response codes:
+// - 200: OK
+// - 400: Bad Request
+// - 401: Unauthorized
+// - 404: Not Found
+func ExportTranscript(writer http.ResponseWriter, request *http.Request) {
+	log.Debug("[handlers.ExportTranscript] Request received")
+	email, errAuth := database.Middleware(request)
+	if errAuth != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	session, errSession := accessibleSession(email, request)
+	if errSession != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	filename := "transcript-" + session.ID
+	switch request.URL.Query().Get("format") {
+	case "", "text":
+		writer.Header().Add("Content-Type", "text/plain; charset=utf-8")
+		writer.Header().Add("Content-Disposition", `attachment; filename="`+filename+`.txt"`)
+		writer.Write([]byte(session.Transcript().Text()))
+	case "html":
+		writer.Header().Add("Content-Type", "text/html; charset=utf-8")
+		writer.Header().Add("Content-Disposition", `attachment; filename="`+filename+`.html"`)
+		writer.Write([]byte(session.Transcript().HTML(filename)))
+	default:
+		writer.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// Get the session in the path of the request if the container is owned by or shared with `email`
+func accessibleSession(email string, request *http.Request) (*driver.Session, error) {
+	containerID := request.PathValue("containerID")
+	_, owner, _, errAccess := database.GetContainerAccess(email, containerID)
+	if errAccess != nil {
+		return nil, errAccess
+	}
+	return ownedSession(owner, containerID, request.PathValue("sessionID"))
+}
@@ -32,6 +32,62 @@ func CreateServer() *http.Server {
 	password := os.Getenv("PG_PASSWORD")
 	database.InitDB(user, db_name, sslmode, password)
 	driver.InitClient()
+	driver.InitRecordings(envString("RECORDINGS_DIR", driver.DefaultRecordingsDir))
+	driver.InitExecutionLimits(driver.ExecutionLimits{
+		MemoryMB:       int64(envInt("EXEC_MEMORY_MB", driver.DefaultMemoryMB)),
+		CPUs:           envFloat("EXEC_CPUS", driver.DefaultCPUs),
+		PidsLimit:      int64(envInt("EXEC_PIDS_LIMIT", driver.DefaultPidsLimit)),
+		MaxOutputBytes: envInt("EXEC_MAX_OUTPUT_BYTES", driver.DefaultMaxOutputBytes),
+		Timeout:        envDuration("EXEC_TIMEOUT", driver.DefaultExecTimeout),
+	})
+	driver.InitEnvDenylist(envList("EXEC_ENV_DENYLIST"))
+	database.StartExecutionRetention(envDuration("EXECUTION_RETENTION", 0))
+	driver.InitDependencies(driver.DependencyConfig{
+		Env:     envList("DEPENDENCY_MIRROR_ENV"),
+		Timeout: envDuration("DEPENDENCY_TIMEOUT", driver.DefaultInstallTimeout),
+	})
+	driver.InitBuildCaches(driver.BuildCacheConfig{
+		Enabled:       envBool("BUILD_CACHE", true),
+		MaxBytes:      int64(envInt("BUILD_CACHE_MAX_MB", driver.DefaultBuildCacheMaxBytes/(1024*1024))) * 1024 * 1024,
+		MaxTotalBytes: int64(envInt("BUILD_CACHE_MAX_TOTAL_MB", driver.DefaultBuildCacheMaxTotalBytes/(1024*1024))) * 1024 * 1024,
+	})
+	driver.InitQueue(driver.QueueConfig{
+		Workers:     envInt("QUEUE_WORKERS", driver.DefaultQueueWorkers),
+		UserWorkers: envInt("QUEUE_USER_WORKERS", driver.DefaultQueueUserWorkers),
+		MaxDepth:    envInt("QUEUE_MAX_DEPTH", driver.DefaultQueueMaxDepth),
+		UserDepth:   envInt("QUEUE_USER_DEPTH", driver.DefaultQueueUserDepth),
+		Retention:   envDuration("QUEUE_JOB_RETENTION", driver.DefaultJobRetention),
+	})
+	driver.InitPool(driver.PoolConfig{
+		Size:           envInt("POOL_SIZE", 0),
+		MaxContainers:  envInt("POOL_MAX_CONTAINERS", driver.DefaultPoolMaxContainers),
+		MaxAge:         envDuration("POOL_MAX_AGE", driver.DefaultPoolMaxAge),
+		HealthInterval: envDuration("POOL_HEALTH_INTERVAL", driver.DefaultPoolHealthInterval),
+	})
+	warmPool()
+	// Audit trail of the terminal input
+	var audit driver.AuditSink
+	if envBool("AUDIT_MODE", false) {
+		audit = database.StartAuditLog(database.AuditConfig{
+			QueueSize: envInt("AUDIT_QUEUE_SIZE", database.DefaultAuditQueueSize),
+			WhenFull:  database.AuditFullPolicy(envString("AUDIT_WHEN_FULL", string(database.AuditBlock))),
+		}).Record
+		database.StartAuditRetention(envDuration("AUDIT_RETENTION", 0))
+	}
+	driver.InitSessions(driver.SessionConfig{
+		DetachTimeout:    envDuration("CONSOLE_DETACH_TIMEOUT", driver.DefaultDetachTimeout),
+		ScrollbackSize:   envInt("CONSOLE_SCROLLBACK_BYTES", driver.DefaultScrollbackSize),
+		ClientQueueBytes: envInt("CONSOLE_CLIENT_QUEUE_BYTES", driver.DefaultClientQueueBytes),
+		SlowConsumer:     driver.SlowConsumerPolicy(envString("CONSOLE_SLOW_CONSUMER", string(driver.SlowConsumerDisconnect))),
+		PingInterval:     envDuration("CONSOLE_PING_INTERVAL", driver.DefaultPingInterval),
+		WarningBefore:    envDuration("CONSOLE_WARNING_BEFORE", driver.DefaultWarningBefore),
+		DefaultPolicy: driver.SessionPolicy{
+			IdleTimeout: envDuration("CONSOLE_IDLE_TIMEOUT", 0),
+			MaxDuration: envDuration("CONSOLE_MAX_DURATION", 0),
+		},
+		TranscriptLines: envInt("CONSOLE_TRANSCRIPT_LINES", driver.DefaultTranscriptLines),
+		Audit:           audit,
+	})
 
 	// Enable CORS origin any
 	http.HandleFunc("OPTIONS /", enableCors)
@@ -48,11 +104,54 @@ func CreateServer() *http.Server {
 	http.Handle("GET /container/resize", middleware(handlers.HandleResize))
 	http.Handle("DELETE /container/{containerID}", middleware(handlers.DeleteContainer))
 	http.Handle("POST /containers/fullstop", middleware(handlers.HandleFullStop))
+	http.Handle("POST /container/{containerID}/sessions", middleware(handlers.NewSession))
+	http.Handle("GET /container/{containerID}/sessions", middleware(handlers.ListSessions))
+	http.Handle("DELETE /container/{containerID}/sessions/{sessionID}", middleware(handlers.CloseSession))
+	http.Handle("POST /container/{containerID}/shares", middleware(handlers.ShareContainer))
+	http.Handle("GET /container/{containerID}/shares", middleware(handlers.ListShares))
+	http.Handle("DELETE /container/{containerID}/shares/{email}", middleware(handlers.RevokeShare))
+	http.Handle("PUT /container/{containerID}/recording", middleware(handlers.SetRecording))
+	http.Handle("GET /container/{containerID}/recordings", middleware(handlers.ListRecordings))
+	http.Handle("GET /container/{containerID}/recordings/{recordingID}", middleware(handlers.DownloadRecording))
+	http.Handle("DELETE /container/{containerID}/recordings/{recordingID}", middleware(handlers.DeleteRecording))
+	http.Handle("GET /container/{containerID}/recordings/{recordingID}/replay", middleware(handlers.ReplayRecording))
+	http.Handle("GET /container/{containerID}/sessions/{sessionID}/transcript", middleware(handlers.ExportTranscript))
+	http.Handle("GET /container/{containerID}/sessions/{sessionID}/transcript/search", middleware(handlers.SearchTranscript))
+	http.Handle("GET /admin/languages", middleware(handlers.ListLanguages))
+	http.Handle("POST /admin/languages", middleware(handlers.AddLanguage))
+	http.Handle("PUT /admin/languages/{name}", middleware(handlers.UpdateLanguage))
+	http.Handle("DELETE /admin/languages/{name}", middleware(handlers.DeleteLanguage))
+	http.Handle("GET /admin/pool", middleware(handlers.GetPoolStats))
+	http.Handle("GET /admin/queue", middleware(handlers.GetQueueStats))
+	http.Handle("GET /admin/audit", middleware(handlers.QueryAudit))
+	http.Handle("GET /admin/audit/export", middleware(handlers.ExportAudit))
+	http.Handle("GET /admin/audit/verify", middleware(handlers.VerifyAudit))
+	http.Handle("DELETE /admin/audit", middleware(handlers.PurgeAudit))
 	http.Handle("GET /container/info", middleware(handlers.InfoContainer))
 	http.Handle("POST /container", middleware(handlers.NewContainer))
 	http.Handle("GET /container", middleware(handlers.ListContainers))
 	http.Handle("GET /images", middleware(handlers.GetImages))
+	http.Handle("GET /languages", middleware(handlers.GetLanguages))
 	http.Handle("POST /code", middleware(handlers.PostCodeHandler))
+	http.Handle("POST /code/stream", middleware(handlers.StreamCodeHandler))
+	http.Handle("POST /code/judge", middleware(handlers.JudgeCodeHandler))
+	http.Handle("POST /code/jobs", middleware(handlers.SubmitJob))
+	http.Handle("GET /code/jobs/{id}", middleware(handlers.GetJob))
+	http.Handle("GET /code/jobs/{id}/result", middleware(handlers.GetJobResult))
+	http.Handle("DELETE /code/jobs/{id}", middleware(handlers.CancelJob))
+	http.Handle("GET /code/executions", middleware(handlers.ListExecutions))
+	http.Handle("GET /code/executions/{id}", middleware(handlers.GetExecution))
+	http.Handle("POST /code/executions/{id}/run", middleware(handlers.RerunExecution))
+	http.Handle("DELETE /code/executions/{id}", middleware(handlers.DeleteExecution))
+	http.Handle("GET /code/ws", middleware(handlers.InteractiveCodeHandler))
+	http.Handle("POST /snippets", middleware(handlers.CreateSnippet))
+	http.Handle("GET /snippets", middleware(handlers.ListSnippets))
+	http.Handle("GET /snippets/public", middleware(handlers.ListPublicSnippets))
+	http.Handle("GET /snippets/{id}", middleware(handlers.GetSnippet))
+	http.Handle("PUT /snippets/{id}", middleware(handlers.UpdateSnippet))
+	http.Handle("DELETE /snippets/{id}", middleware(handlers.DeleteSnippet))
+	http.Handle("POST /snippets/{id}/fork", middleware(handlers.ForkSnippet))
+	http.Handle("POST /snippets/{id}/run", middleware(handlers.RunSnippet))
 
 	return s
 }
@@ -62,7 +161,7 @@ func enableCors(w http.ResponseWriter, r *http.Request) {
 	(w).Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
 	(w).Header().Set("Access-Control-Allow-Credentials", "true")
 	(w).Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	(w).Header().Set("Access-Control-Allow-Methods", "GET,POST,DELETE")
+	(w).Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE")
 }
 
 func middleware(next http.HandlerFunc) http.Handler {
@@ -73,3 +172,19 @@ func middleware(next http.HandlerFunc) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Fill the container pool for the enabled languages
+func warmPool() {
+	languages, err := database.GetLanguages()
+	if err != nil {
+		log.Error("[server.warmPool] Error while getting the languages", "error", err)
+		return
+	}
+	enabled := []driver.Language{}
+	for _, language := range languages {
+		if language.Enabled {
+			enabled = append(enabled, language)
+		}
+	}
+	driver.WarmPool(enabled)
+}
@@ -1,11 +1,11 @@
-import { Editor } from "@monaco-editor/react";
+import { Editor, Monaco } from "@monaco-editor/react";
 import { FitAddon } from "@xterm/addon-fit";
 import { Terminal } from "@xterm/xterm";
 import monaco from "monaco-editor";
 import { useEffect, useRef, useState } from "react";
 import { useNavigate } from "react-router-dom";
 
-import { RunCode } from "../services/code";
+import { Diagnostic, LanguageInfo, RunCode } from "../services/code";
 import { TopBar } from "./TopBar";
 import { LoadTerminal, checkAuth } from "./util";
 import { ServiceError } from "../services/error";
@@ -15,18 +15,50 @@ export function CodeEditor() {
   const navigate = useNavigate();
 
   const editorRef = useRef<monaco.editor.IStandaloneCodeEditor | null>(null);
+  const monacoRef = useRef<Monaco | null>(null);
   const initialized = useRef(false);
-  const [lang, setLang] = useState("rust" as string);
+  const [language, setLanguage] = useState(null as LanguageInfo | null);
+  const lang = language?.name ?? "rust";
   const [content, setContent] = useState("" as string);
   const terminal: React.MutableRefObject<Terminal | null> = useRef(null);
   const fitAddon: React.MutableRefObject<FitAddon | null> = useRef(null);
 
   function handleEditorDidMount(
     editor: monaco.editor.IStandaloneCodeEditor,
+    monacoInstance: Monaco,
   ) {
     editorRef.current = editor;
+    monacoRef.current = monacoInstance;
   }
 
+  // Show the diagnostics of the compiler as markers in the editor
+  const showDiagnostics = (diagnostics: Diagnostic[]) => {
+    const model = editorRef.current?.getModel();
+    const monacoInstance = monacoRef.current;
+    if (!model || !monacoInstance) {
+      return;
+    }
+    const severities = {
+      error: monacoInstance.MarkerSeverity.Error,
+      warning: monacoInstance.MarkerSeverity.Warning,
+      note: monacoInstance.MarkerSeverity.Info,
+    };
+    const markers = diagnostics.map((diagnostic) => {
+      const line = Math.min(Math.max(diagnostic.line, 1), model.getLineCount());
+      return {
+        startLineNumber: line,
+        startColumn: Math.max(diagnostic.column, 1),
+        endLineNumber: line,
+        endColumn: model.getLineMaxColumn(line),
+        message: diagnostic.code
+          ? `${diagnostic.message} (${diagnostic.code})`
+          : diagnostic.message,
+        severity: severities[diagnostic.severity] ?? severities.error,
+      };
+    });
+    monacoInstance.editor.setModelMarkers(model, "compiler", markers);
+  };
+
   window.onresize = () => {
     if (editorRef.current) {
       editorRef.current.layout({} as monaco.editor.IDimension);
@@ -58,12 +90,32 @@ export function CodeEditor() {
   const handleRunCode = async () => {
     const RED = "\x1b[31m";
     const RESET = "\x1b[0m";
-    const result = await RunCode(content, lang);
+    if (!language) {
+      return;
+    }
+    const result = await RunCode(content, language.name);
     if (result.type === "Ok") {
+      showDiagnostics(result.value.diagnostics ?? []);
       // There's data in result
       if (terminal.current) {
+        // The program runs without a tty, the terminal needs CRLF line endings
+        const crlf = (text: string) => text.replace(/\r?\n/g, "\r\n");
+        const output = result.value;
         terminal.current.write("\r\n");
-        terminal.current.write(result.value);
+        terminal.current.write(crlf(output.stdout));
+        if (output.stderr) {
+          terminal.current.write(`${RED}${crlf(output.stderr)}${RESET}`);
+        }
+        if (output.stdout_truncated || output.stderr_truncated) {
+          terminal.current.write(`\r\n${RED}   Output truncated   ${RESET}`);
+        }
+        if (output.oom_killed) {
+          terminal.current.write(`\r\n${RED}   Killed: out of memory   ${RESET}`);
+        } else if (output.timed_out) {
+          terminal.current.write(`\r\n${RED}   Killed: time limit exceeded   ${RESET}`);
+        } else if (output.exit_code !== 0) {
+          terminal.current.write(`\r\n${RED}   Exited with code ${output.exit_code}   ${RESET}`);
+        }
         terminal.current.write("\r\n");
       }
     } else {
@@ -91,29 +143,8 @@ export function CodeEditor() {
     const url = URL.createObjectURL(file);
     const a = document.createElement("a");
     a.href = url;
-    switch (lang) {
-      case "rust":
-        a.download = "code.rs";
-        break;
-      case "python":
-        a.download = "code.py";
-        break;
-      case "c":
-        a.download = "code.c";
-        break;
-      case "cpp":
-        a.download = "code.cpp";
-        break;
-      case "go":
-        a.download = "code.go";
-        break;
-      case "bash":
-        a.download = "code.sh";
-        break;
-      case "typescript":
-        a.download = "code.ts";
-        break;
-    }
+    // Named like the default file of the language, without its directory
+    a.download = language?.filename.split("/").pop() ?? "code.txt";
     document.body.appendChild(a);
     a.click();
     URL.revokeObjectURL(url);
@@ -123,7 +154,7 @@ export function CodeEditor() {
     <>
       <div className="h-full flex flex-col">
         <TopBar
-          setLanguage={setLang}
+          setLanguage={setLanguage}
           setContent={setContent}
           handleDownload={handleDownload}
           handleRunCode={handleRunCode}
@@ -142,7 +173,9 @@ export function CodeEditor() {
               height="100%" // Set height to 100% to fill parent vertically
               defaultValue="// Add some code here!"
               theme="vs-dark"
-              onMount={(editor) => handleEditorDidMount(editor)}
+              onMount={(editor, monacoInstance) =>
+                handleEditorDidMount(editor, monacoInstance)
+              }
               onChange={(value) => setContent(value ?? "")}
               value={content}
             />
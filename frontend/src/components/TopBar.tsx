@@ -1,16 +1,27 @@
 import React, { useRef } from "react";
 
+import { GetLanguages, LanguageInfo } from "../services/code";
 import { capitalize } from "./util";
 
-interface Lang {
-  name: string;
-  link: string;
-  prettyName: string;
-  invert?: boolean;
-}
+// Logos of the languages we know, the others get the icon of the app
+const logos: Record<string, string> = {
+  rust: "https://cdn.svgporn.com/logos/rust.svg",
+  python: "https://cdn.svgporn.com/logos/python.svg",
+  typescript: "https://cdn.svgporn.com/logos/typescript-icon.svg",
+  c: "https://cdn.svgporn.com/logos/c.svg",
+  cpp: "https://cdn.svgporn.com/logos/c-plusplus.svg",
+  go: "https://cdn.svgporn.com/logos/go.svg",
+  bash: "https://cdn.svgporn.com/logos/bash-icon.svg",
+};
+
+const logo = (lang: LanguageInfo) => logos[lang.name] ?? "/icon.svg";
+
+// Extension of the default file of the language, e.g. `rs` for `src/main.rs`
+const extension = (filename: string) =>
+  filename.split("/").pop()?.split(".").pop();
 
 export function TopBar(props: {
-  setLanguage: (lang: string) => void;
+  setLanguage: (lang: LanguageInfo) => void;
   setContent: (content: string) => void;
   handleDownload: () => void;
   handleRunCode: () => Promise<void>;
@@ -18,46 +29,19 @@ export function TopBar(props: {
 }) {
   const [loading, setLoading] = React.useState(false);
   const [menuState, setMenuState] = React.useState(" hidden ");
-  const languages: Lang[] = [
-    {
-      name: "rust",
-      link: "https://cdn.svgporn.com/logos/rust.svg",
-      prettyName: "Rust",
-      invert: true,
-    },
-    {
-      name: "python",
-      link: "https://cdn.svgporn.com/logos/python.svg",
-      prettyName: "Python",
-    },
-    {
-      name: "typescript",
-      link: "https://cdn.svgporn.com/logos/typescript-icon.svg",
-      prettyName: "TypeScript",
-    },
-    { name: "c", link: "https://cdn.svgporn.com/logos/c.svg", prettyName: "C" },
-    {
-      name: "cpp",
-      link: "https://cdn.svgporn.com/logos/c-plusplus.svg",
-      prettyName: "C++",
-    },
-    {
-      name: "go",
-      link: "https://cdn.svgporn.com/logos/go.svg",
-      prettyName: "Go",
-    },
-    {
-      name: "bash",
-      link: "https://cdn.svgporn.com/logos/bash-icon.svg",
-      prettyName: "bash",
-    },
-  ];
-  const [lang, setLangBar] = React.useState(languages[0].name);
-  const [langLink, setLangLink] = React.useState(languages[0].link);
+  // The languages are registered in the server, the first one is selected once they're loaded
+  const [languages, setLanguages] = React.useState<LanguageInfo[]>([]);
+  const [lang, setLangBar] = React.useState("");
+  const [langLink, setLangLink] = React.useState("/icon.svg");
 
   // Handle loose focus on menu
   React.useEffect(() => {
-    props.setLanguage(languages[0].name);
+    GetLanguages().then((result) => {
+      if (result.type === "Ok" && result.value.length > 0) {
+        setLanguages(result.value);
+        handleLang(result.value[0]);
+      }
+    });
     const handleClick = (e: MouseEvent) => {
       const menu = document.getElementById("menu");
       const button = document.getElementById("user-menu-button");
@@ -84,10 +68,10 @@ export function TopBar(props: {
       setMenuState(" hidden ");
     }
   };
-  const handleLang = (lang: Lang) => {
-    props.setLanguage(lang.name);
-    setLangBar(lang.prettyName);
-    setLangLink(lang.link);
+  const handleLang = (lang: LanguageInfo) => {
+    props.setLanguage(lang);
+    setLangBar(lang.display_name);
+    setLangLink(logo(lang));
     setMenuState(" hidden ");
   };
   const fileInputRef = useRef(null);
@@ -108,57 +92,13 @@ export function TopBar(props: {
     const eventTarget = event.target as HTMLInputElement;
     if (!eventTarget.files) return;
     const file = eventTarget.files[0];
-    // Get file extension
-    const extension = file.name.split(".").pop();
-    switch (extension) {
-      case "rs":
-        props.setLanguage("rust");
-        const lang = languages.find((lang) => lang.name === "rust");
-        setLangBar(lang?.prettyName ?? "Rust");
-        setLangLink(lang?.link ?? "https://cdn.svgporn.com/logos/rust.svg");
-        break;
-      case "py":
-        props.setLanguage("python");
-        const lang1 = languages.find((lang) => lang.name === "python");
-        setLangBar(lang1?.prettyName ?? "Python");
-        setLangLink(lang1?.link ?? "https://cdn.svgporn.com/logos/python.svg");
-        break;
-      case "ts":
-        props.setLanguage("typescript");
-        const lang2 = languages.find((lang) => lang.name === "typescript");
-        setLangBar(lang2?.prettyName ?? "TypeScript");
-        setLangLink(
-          lang2?.link ?? "https://cdn.svgporn.com/logos/typescript-icon.svg"
-        );
-        break;
-      case "c":
-        props.setLanguage("c");
-        const lang3 = languages.find((lang) => lang.name === "c");
-        setLangBar(lang3?.prettyName ?? "C");
-        setLangLink(lang3?.link ?? "https://cdn.svgporn.com/logos/c.svg");
-        break;
-      case "cpp":
-        props.setLanguage("cpp");
-        const lang4 = languages.find((lang) => lang.name === "cpp");
-        setLangBar(lang4?.prettyName ?? "C++");
-        setLangLink(
-          lang4?.link ?? "https://cdn.svgporn.com/logos/c-plusplus.svg"
-        );
-        break;
-      case "go":
-        props.setLanguage("go");
-        const lang5 = languages.find((lang) => lang.name === "go");
-        setLangBar(lang5?.prettyName ?? "Go");
-        setLangLink(lang5?.link ?? "https://cdn.svgporn.com/logos/go.svg");
-        break;
-      case "sh":
-        props.setLanguage("bash");
-        const lang6 = languages.find((lang) => lang.name === "bash");
-        setLangBar(lang6?.prettyName ?? "Bash");
-        setLangLink(
-          lang6?.link ?? "https://cdn.svgporn.com/logos/bash-icon.svg"
-        );
-        break;
+    // The language of the file is the one whose default file has the same extension
+    const fileExtension = file.name.split(".").pop();
+    const lang = languages.find(
+      (lang) => extension(lang.filename) === fileExtension
+    );
+    if (lang) {
+      handleLang(lang);
     }
     eventTarget.files[0].text().then((text) => {
       props.setContent(text);
@@ -233,11 +173,11 @@ export function TopBar(props: {
                           onClick={() => handleLang(lang)}
                         >
                           <img
-                            src={lang.link}
+                            src={logo(lang)}
                             alt=""
                             className="mr-2 h-4 w-4"
                           />
-                          {lang.prettyName}
+                          {lang.display_name}
                         </button>
                       );
                     })}
@@ -1,10 +1,65 @@
 import axios from "./axios";
 import { Result, ServiceError, fromNumber } from "./error";
 
+/**
+ * Error or warning parsed from the compiler output
+ */
+export interface Diagnostic {
+    file: string;
+    line: number;
+    column: number;
+    severity: "error" | "warning" | "note";
+    message: string;
+    code?: string;
+}
+
+/**
+ * Result of a code execution
+ */
+export interface ExecutionResult {
+    stdout: string;
+    stderr: string;
+    exit_code: number;
+    wall_time_ms: number;
+    cpu_time_ms: number;
+    oom_killed: boolean;
+    timed_out: boolean;
+    stdout_truncated: boolean;
+    stderr_truncated: boolean;
+    diagnostics?: Diagnostic[];
+}
+
+/**
+ * Language available in the code runner
+ */
+export interface LanguageInfo {
+    name: string;
+    display_name: string;
+    version: string;
+    filename: string;
+}
+
+const GetLanguages = async (): Promise<Result<LanguageInfo[], ServiceError>> => {
+    try {
+        const response = await axios.get(`/languages`);
+        if (response.status === 200) {
+            return { type: "Ok", value: response.data };
+        }
+    } catch (error) {
+        if (axios.isAxiosError(error)) {
+            return {
+                type: "Err",
+                error: fromNumber(error.response?.status || 0),
+            };
+        }
+    }
+    return { type: "Err", error: ServiceError.Unknown };
+};
+
 const RunCode = async (
     payload: string,
     language: string
-): Promise<Result<string, ServiceError>> => {
+): Promise<Result<ExecutionResult, ServiceError>> => {
     try {
         const response = await axios.post(`/code`, {
             code: payload,
@@ -27,4 +82,4 @@ const RunCode = async (
     return { type: "Err", error: ServiceError.Unknown };
 };
 
-export { RunCode };
+export { GetLanguages, RunCode };
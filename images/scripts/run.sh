@@ -2,4 +2,4 @@
 set -e
 
 gcc -Wall -Wextra -Wpedantic -o /app/main /app/main.c
-/app/main
+/app/main "$@"
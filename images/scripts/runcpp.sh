@@ -2,4 +2,4 @@
 set -e
 g++ -Wall -Wextra -Wpedantic -o /app/main /app/main.cpp
 
-/app/main
+/app/main "$@"
@@ -19,8 +19,11 @@ CREATE TABLE IF NOT EXISTS terminals(
   name VARCHAR(64) NOT NULL,
   auto_remove BOOLEAN NOT NULL,
   network_enabled BOOLEAN NOT NULL,
-  command VARCHAR(64) NOT NULL
+  command VARCHAR(64) NOT NULL,
+  record BOOLEAN NOT NULL DEFAULT false
 );
+-- Databases created before recordings existed
+ALTER TABLE terminals ADD COLUMN IF NOT EXISTS record BOOLEAN NOT NULL DEFAULT false;
 
 CREATE TABLE IF NOT EXISTS sessions(
   id SERIAL PRIMARY KEY,
@@ -40,3 +43,237 @@ INSERT INTO images(image_tag, commands) VALUES ('python:3.11', '{"/usr/bin/pytho
 INSERT INTO images(image_tag, commands) VALUES ('alpine:3.14', '{"/bin/sh"}');
 INSERT INTO images(image_tag, commands) VALUES ('debian:stable', '{"/bin/bash","/bin/sh"}');
 INSERT INTO images(image_tag, commands) VALUES ('archlinux:base-devel', '{"/bin/bash","/bin/sh"}');
+
+-- Users the owner of a container shared the console with
+CREATE TABLE IF NOT EXISTS shares(
+  id SERIAL PRIMARY KEY,
+  containerid VARCHAR(64) NOT NULL,
+  FOREIGN KEY (containerid) REFERENCES terminals(containerid) ON DELETE CASCADE,
+  email VARCHAR(64) NOT NULL,
+  FOREIGN KEY (email) REFERENCES users(email),
+  role VARCHAR(16) NOT NULL,
+  UNIQUE (containerid, email)
+);
+
+-- Idle timeout and max duration of console sessions in seconds, NULL falls back to the next match.
+-- Rows with a NULL email apply to every user of the image, rows with a NULL image_tag to every image
+-- of the user. The most specific row wins.
+CREATE TABLE IF NOT EXISTS console_policies(
+  id SERIAL PRIMARY KEY,
+  image_tag VARCHAR(64),
+  email VARCHAR(64),
+  FOREIGN KEY (email) REFERENCES users(email),
+  idle_timeout INTEGER,
+  max_duration INTEGER
+);
+-- One policy per user and image, NULL meaning any. A UNIQUE constraint lets NULLs repeat, tables
+-- created with one keep the last policy written.
+DELETE FROM console_policies older USING console_policies newer
+  WHERE older.image_tag IS NOT DISTINCT FROM newer.image_tag AND older.email IS NOT DISTINCT FROM newer.email
+  AND older.id < newer.id;
+ALTER TABLE console_policies DROP CONSTRAINT IF EXISTS console_policies_image_tag_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS console_policies_image_tag_email
+  ON console_policies(COALESCE(image_tag, ''), COALESCE(email, ''));
+
+-- Administrators of the deployment
+CREATE TABLE IF NOT EXISTS admins(
+  email VARCHAR(64) PRIMARY KEY,
+  FOREIGN KEY (email) REFERENCES users(email)
+);
+
+-- Audit trail of the terminal input. Each entry is chained to the previous one with a SHA-256 hash
+-- and the table is append only, deletes are only allowed by the retention purge.
+CREATE TABLE IF NOT EXISTS audit_log(
+  id BIGSERIAL PRIMARY KEY,
+  created_at TIMESTAMPTZ NOT NULL,
+  email VARCHAR(64) NOT NULL,
+  containerid VARCHAR(64) NOT NULL,
+  sessionid VARCHAR(64) NOT NULL,
+  client_ip VARCHAR(64) NOT NULL,
+  input BYTEA NOT NULL,
+  prev_hash CHAR(64) NOT NULL,
+  hash CHAR(64) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_log_created_at ON audit_log(created_at);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'DELETE' AND current_setting('webconsole.audit_purge', true) = 'on' THEN
+    RETURN OLD;
+  END IF;
+  RAISE EXCEPTION 'audit_log is append only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER audit_log_no_update BEFORE UPDATE OR DELETE ON audit_log
+  FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+CREATE OR REPLACE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+  FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+
+-- Hash of the last entry deleted by each purge, the verification of the chain starts from the latest
+CREATE TABLE IF NOT EXISTS audit_checkpoints(
+  id BIGSERIAL PRIMARY KEY,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  last_id BIGINT NOT NULL,
+  hash CHAR(64) NOT NULL
+);
+CREATE OR REPLACE FUNCTION audit_checkpoints_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_checkpoints is append only';
+END;
+$$ LANGUAGE plpgsql;
+CREATE OR REPLACE TRIGGER audit_checkpoints_no_update BEFORE UPDATE OR DELETE ON audit_checkpoints
+  FOR EACH ROW EXECUTE FUNCTION audit_checkpoints_append_only();
+CREATE OR REPLACE TRIGGER audit_checkpoints_no_truncate BEFORE TRUNCATE ON audit_checkpoints
+  FOR EACH STATEMENT EXECUTE FUNCTION audit_checkpoints_append_only();
+
+-- Resource limits of code executions, NULL falls back to the next match. Rows with a NULL email apply
+-- to every user of the language, rows with a NULL language to every language of the user. The most
+-- specific row wins. Timeout in seconds.
+CREATE TABLE IF NOT EXISTS execution_limits(
+  id SERIAL PRIMARY KEY,
+  language VARCHAR(32),
+  email VARCHAR(64),
+  FOREIGN KEY (email) REFERENCES users(email),
+  memory_mb INTEGER,
+  cpus REAL,
+  pids_limit INTEGER,
+  output_bytes INTEGER,
+  timeout INTEGER
+);
+-- One row per user and language, NULL meaning any. Same migration as console_policies.
+DELETE FROM execution_limits older USING execution_limits newer
+  WHERE older.language IS NOT DISTINCT FROM newer.language AND older.email IS NOT DISTINCT FROM newer.email
+  AND older.id < newer.id;
+ALTER TABLE execution_limits DROP CONSTRAINT IF EXISTS execution_limits_language_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS execution_limits_language_email
+  ON execution_limits(COALESCE(language, ''), COALESCE(email, ''));
+
+-- Languages of the code runner. The files of the project are copied to `source_path`, the working
+-- directory, in a container of `image`:`tag`. Then `build_command` (if any) and `run_command` are run
+-- by a shell, with the full path of the entrypoint in `$ENTRYPOINT`. `filename` is the default
+-- entrypoint. `diagnostics` is the parser of the stderr of `build_command`: gcc, go, rustc, tsc or
+-- empty.
+-- Projects with the `manifest` file first run `install_command` with network and a volume mounted
+-- at `cache_path`, the dependencies must be installed in the project since only it is kept.
+CREATE TABLE IF NOT EXISTS languages(
+  id SERIAL PRIMARY KEY,
+  name VARCHAR(32) UNIQUE NOT NULL,
+  display_name VARCHAR(64) NOT NULL,
+  version VARCHAR(32) NOT NULL DEFAULT '',
+  image VARCHAR(255) NOT NULL,
+  tag VARCHAR(128) NOT NULL DEFAULT 'latest',
+  source_path VARCHAR(255) NOT NULL,
+  filename VARCHAR(64) NOT NULL,
+  build_command TEXT NOT NULL DEFAULT '',
+  run_command TEXT NOT NULL,
+  enabled BOOLEAN NOT NULL DEFAULT true,
+  diagnostics VARCHAR(16) NOT NULL DEFAULT '',
+  manifest VARCHAR(64) NOT NULL DEFAULT '',
+  install_command TEXT NOT NULL DEFAULT '',
+  cache_path VARCHAR(255) NOT NULL DEFAULT '',
+  build_cache VARCHAR(255) NOT NULL DEFAULT ''
+);
+ALTER TABLE languages ADD COLUMN IF NOT EXISTS diagnostics VARCHAR(16) NOT NULL DEFAULT '';
+ALTER TABLE languages ADD COLUMN IF NOT EXISTS manifest VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE languages ADD COLUMN IF NOT EXISTS install_command TEXT NOT NULL DEFAULT '';
+ALTER TABLE languages ADD COLUMN IF NOT EXISTS cache_path VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE languages ADD COLUMN IF NOT EXISTS build_cache VARCHAR(255) NOT NULL DEFAULT '';
+
+INSERT INTO languages (name, display_name, version, image, tag, source_path, filename, build_command, run_command, diagnostics, manifest, install_command, cache_path, build_cache) VALUES
+  ('rust', 'Rust', '1.67', 'customrust', 'latest', '/usr/src/app/devcontainer', 'src/main.rs', '/usr/local/cargo/bin/cargo build --quiet', '/usr/local/cargo/bin/cargo run --quiet -- "$@"', 'rustc',
+    'Cargo.toml', 'mkdir -p .cargo && /usr/local/cargo/bin/cargo vendor --quiet > .cargo/config.toml', '/usr/local/cargo/registry', '/usr/src/app/devcontainer/target'),
+  ('python', 'Python', '3.11', 'custompython', 'latest', '/app', 'main.py', '', 'PYTHONPATH=/app/.packages python3 "$ENTRYPOINT" "$@"', '',
+    'requirements.txt', 'pip install --quiet --only-binary=:all: --target /app/.packages -r requirements.txt', '/root/.cache/pip', ''),
+  ('c', 'C', 'gcc 4.9', 'customc', 'latest', '/app', 'main.c', 'mkdir -p /tmp/obj && for f in $(find . -name ''*.c''); do mkdir -p "/tmp/obj/$(dirname "$f")" && ccache gcc -Wall -Wextra -Wpedantic -c "$f" -o "/tmp/obj/$f.o" || exit 1; done && gcc -o /app/main $(find /tmp/obj -name ''*.o'')', '/app/main "$@"', 'gcc',
+    '', '', '', '/root/.ccache'),
+  ('cpp', 'C++', 'g++ 4.9', 'customcpp', 'latest', '/app', 'main.cpp', 'mkdir -p /tmp/obj && for f in $(find . -name ''*.cpp''); do mkdir -p "/tmp/obj/$(dirname "$f")" && ccache g++ -Wall -Wextra -Wpedantic -c "$f" -o "/tmp/obj/$f.o" || exit 1; done && g++ -o /app/main $(find /tmp/obj -name ''*.o'')', '/app/main "$@"', 'gcc',
+    '', '', '', '/root/.ccache'),
+  ('typescript', 'TypeScript', 'Node 18', 'customts', 'latest', '/app', 'index.ts', 'tsc --noEmit --pretty false "$ENTRYPOINT"', 'ts-node --transpile-only "$ENTRYPOINT" "$@"', 'tsc',
+    'package.json', 'npm install --ignore-scripts --no-audit --no-fund --silent', '/root/.npm', ''),
+  ('go', 'Go', '1.22', 'customgo', 'latest', '/app', 'main.go', '([ -f go.mod ] || go mod init app 2> /dev/null) && go build -o /app/main "$(dirname "$ENTRYPOINT")"', '/app/main "$@"', 'go',
+    'go.mod', 'go mod tidy && go mod vendor', '/go/pkg/mod', '/root/.cache/go-build'),
+  ('bash', 'Bash', '5', 'custombash', 'latest', '/app', 'main.sh', '', 'bash "$ENTRYPOINT" "$@"', '',
+    '', '', '', '')
+ON CONFLICT (name) DO NOTHING;
+-- Languages registered before dependency installs get the manifests and install commands of the seeds,
+-- Python finds the installed dependencies through PYTHONPATH
+UPDATE languages SET manifest = seed.manifest, install_command = seed.install_command, cache_path = seed.cache_path
+  FROM (VALUES
+    ('rust', 'Cargo.toml', 'mkdir -p .cargo && /usr/local/cargo/bin/cargo vendor --quiet > .cargo/config.toml', '/usr/local/cargo/registry'),
+    ('python', 'requirements.txt', 'pip install --quiet --only-binary=:all: --target /app/.packages -r requirements.txt', '/root/.cache/pip'),
+    ('typescript', 'package.json', 'npm install --ignore-scripts --no-audit --no-fund --silent', '/root/.npm'),
+    ('go', 'go.mod', 'go mod tidy && go mod vendor', '/go/pkg/mod')
+  ) AS seed(name, manifest, install_command, cache_path)
+  WHERE languages.name = seed.name AND languages.manifest = '' AND languages.install_command = '';
+UPDATE languages SET run_command = 'PYTHONPATH=/app/.packages python3 "$ENTRYPOINT"'
+  WHERE name = 'python' AND run_command = 'python3 "$ENTRYPOINT"';
+-- The run commands pass the arguments of the program
+UPDATE languages SET run_command = seed.run_command
+  FROM (VALUES
+    ('rust', '/usr/local/cargo/bin/cargo run --quiet', '/usr/local/cargo/bin/cargo run --quiet -- "$@"'),
+    ('python', 'PYTHONPATH=/app/.packages python3 "$ENTRYPOINT"', 'PYTHONPATH=/app/.packages python3 "$ENTRYPOINT" "$@"'),
+    ('c', '/app/main', '/app/main "$@"'),
+    ('cpp', '/app/main', '/app/main "$@"'),
+    ('typescript', 'ts-node "$ENTRYPOINT"', 'ts-node "$ENTRYPOINT" "$@"'),
+    ('go', '/app/main', '/app/main "$@"'),
+    ('bash', 'bash "$ENTRYPOINT"', 'bash "$ENTRYPOINT" "$@"')
+  ) AS seed(name, previous, run_command)
+  WHERE languages.name = seed.name AND languages.run_command = seed.previous;
+-- TypeScript used to be type checked by ts-node at run time, the diagnostics only come from the build
+UPDATE languages SET build_command = 'tsc --noEmit --pretty false "$ENTRYPOINT"', run_command = 'ts-node --transpile-only "$ENTRYPOINT" "$@"'
+  WHERE name = 'typescript' AND build_command = '' AND run_command = 'ts-node "$ENTRYPOINT" "$@"';
+-- C and C++ used to be built in a single gcc call, each file is now compiled through ccache so the
+-- objects are kept in the build cache. The images must be rebuilt with ccache.
+UPDATE languages SET build_command = 'mkdir -p /tmp/obj && for f in $(find . -name ''*.c''); do mkdir -p "/tmp/obj/$(dirname "$f")" && ccache gcc -Wall -Wextra -Wpedantic -c "$f" -o "/tmp/obj/$f.o" || exit 1; done && gcc -o /app/main $(find /tmp/obj -name ''*.o'')', build_cache = '/root/.ccache'
+  WHERE name = 'c' AND build_command = 'gcc -Wall -Wextra -Wpedantic -o /app/main $(find . -name ''*.c'')' AND build_cache = '';
+UPDATE languages SET build_command = 'mkdir -p /tmp/obj && for f in $(find . -name ''*.cpp''); do mkdir -p "/tmp/obj/$(dirname "$f")" && ccache g++ -Wall -Wextra -Wpedantic -c "$f" -o "/tmp/obj/$f.o" || exit 1; done && g++ -o /app/main $(find /tmp/obj -name ''*.o'')', build_cache = '/root/.ccache'
+  WHERE name = 'cpp' AND build_command = 'g++ -Wall -Wextra -Wpedantic -o /app/main $(find . -name ''*.cpp'')' AND build_cache = '';
+
+-- History of the code executions of the users, `files` is the JSON list of the files of the project,
+-- `args` and `env` the JSON arguments and variables of the program and `result` the JSON result of
+-- the execution
+CREATE TABLE IF NOT EXISTS executions(
+  id BIGSERIAL PRIMARY KEY,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  email VARCHAR(64) NOT NULL,
+  FOREIGN KEY (email) REFERENCES users(email),
+  language VARCHAR(32) NOT NULL,
+  entrypoint VARCHAR(255) NOT NULL,
+  files JSONB NOT NULL,
+  stdin TEXT,
+  args JSONB,
+  env JSONB,
+  result JSONB NOT NULL,
+  exit_code BIGINT NOT NULL,
+  wall_time_ms BIGINT NOT NULL
+);
+-- Tables created before programs had arguments and variables
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS args JSONB;
+ALTER TABLE executions ADD COLUMN IF NOT EXISTS env JSONB;
+CREATE INDEX IF NOT EXISTS executions_email_id ON executions(email, id);
+CREATE INDEX IF NOT EXISTS executions_created_at ON executions(created_at);
+
+-- Saved code of the users, `id` is a short random id used in permalinks, `files` is the JSON list of
+-- the files of the project and `args` and `env` the JSON arguments and variables of the program
+CREATE TABLE IF NOT EXISTS snippets(
+  id VARCHAR(16) PRIMARY KEY,
+  email VARCHAR(64) NOT NULL,
+  FOREIGN KEY (email) REFERENCES users(email),
+  name VARCHAR(128) NOT NULL,
+  language VARCHAR(32) NOT NULL,
+  entrypoint VARCHAR(255) NOT NULL,
+  files JSONB NOT NULL,
+  stdin TEXT,
+  args JSONB,
+  env JSONB,
+  visibility VARCHAR(16) NOT NULL DEFAULT 'private' CHECK (visibility IN ('private', 'link', 'public')),
+  forked_from VARCHAR(16) REFERENCES snippets(id) ON DELETE SET NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+-- Tables created before programs had arguments and variables
+ALTER TABLE snippets ADD COLUMN IF NOT EXISTS args JSONB;
+ALTER TABLE snippets ADD COLUMN IF NOT EXISTS env JSONB;
+CREATE INDEX IF NOT EXISTS snippets_email_updated_at ON snippets(email, updated_at);
+CREATE INDEX IF NOT EXISTS snippets_visibility_updated_at ON snippets(visibility, updated_at);